@@ -1,7 +1,10 @@
 package database
 
 import (
+	"strings"
+
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/pemistahl/lingua-go"
 	"gorm.io/gorm"
 )
 
@@ -9,22 +12,214 @@ var AutoMaintainRange = []any{
 	&models.Publisher{},
 	&models.Category{},
 	&models.Tag{},
+	&models.Series{},
 	&models.Post{},
 	&models.PostInsight{},
 	&models.Subscription{},
 	&models.Poll{},
 	&models.PollAnswer{},
+	&models.Annotation{},
+	&models.AccountPreference{},
 }
 
 func RunMigration(source *gorm.DB) error {
+	if source.Migrator().HasTable(&models.PollAnswer{}) &&
+		!source.Migrator().HasIndex(&models.PollAnswer{}, "idx_poll_answer_account") {
+		// Remove the duplicated answers before creating the unique index, only the latest one is kept
+		if err := source.Exec(`
+			DELETE FROM poll_answers a USING poll_answers b
+			WHERE a.poll_id = b.poll_id AND a.account_id = b.account_id
+				AND a.deleted_at IS NULL AND b.deleted_at IS NULL AND a.id < b.id
+		`).Error; err != nil {
+			return err
+		}
+	}
+
+	if source.Migrator().HasTable(&models.Reaction{}) &&
+		!source.Migrator().HasIndex(&models.Reaction{}, "idx_reaction_post_account_symbol") {
+		// Remove the duplicated reactions before creating the unique index, only the earliest one is kept
+		// The vote counters will be corrected by the recalculation afterward
+		if err := source.Exec(`
+			DELETE FROM reactions a USING reactions b
+			WHERE a.post_id = b.post_id AND a.account_id = b.account_id
+				AND a.symbol = b.symbol AND a.id > b.id
+		`).Error; err != nil {
+			return err
+		}
+	}
+
+	if source.Migrator().HasTable(&models.PostInsight{}) &&
+		!source.Migrator().HasIndex(&models.PostInsight{}, "idx_post_insight_pending") {
+		// Fail the duplicated pending jobs before creating the unique index, only the latest one is kept
+		if err := source.Exec(`
+			UPDATE post_insights a SET status = ?, error = 'generation was interrupted'
+			FROM post_insights b
+			WHERE a.post_id = b.post_id AND a.content_hash = b.content_hash
+				AND a.status = ? AND b.status = ?
+				AND a.deleted_at IS NULL AND b.deleted_at IS NULL AND a.id < b.id
+		`, models.PostInsightFailed, models.PostInsightPending, models.PostInsightPending).Error; err != nil {
+			return err
+		}
+	}
+
+	// Remove the duplicated subscriptions before creating the unique indexes, only the earliest one is kept
+	dedupeSubscription := false
+	if source.Migrator().HasTable(&models.Subscription{}) {
+		for index, column := range map[string]string{
+			"idx_subscription_account":  "account_id",
+			"idx_subscription_tag":      "tag_id",
+			"idx_subscription_category": "category_id",
+			"idx_subscription_series":   "series_id",
+		} {
+			if source.Migrator().HasIndex(&models.Subscription{}, index) {
+				continue
+			}
+			if err := source.Exec(`
+				DELETE FROM subscriptions a USING subscriptions b
+				WHERE a.follower_id = b.follower_id AND a.` + column + ` = b.` + column + `
+					AND a.deleted_at IS NULL AND b.deleted_at IS NULL AND a.id > b.id
+			`).Error; err != nil {
+				return err
+			}
+			dedupeSubscription = true
+		}
+	}
+
+	if err := migrateReactionTarget(source); err != nil {
+		return err
+	}
+
+	// The link previews were referenced by the posts, they must be created before the join table
+	if err := source.AutoMigrate(&models.LinkPreview{}); err != nil {
+		return err
+	}
+
+	if err := migratePostLanguage(source); err != nil {
+		return err
+	}
+
+	// The follower count is maintained incrementally, the publishers existed before need to be counted once,
+	// so do they after the duplicated subscriptions were removed
+	backfillFollower := dedupeSubscription || (source.Migrator().HasTable(&models.Publisher{}) &&
+		!source.Migrator().HasColumn(&models.Publisher{}, "TotalFollower"))
+
 	if err := source.AutoMigrate(
 		append(
 			AutoMaintainRange,
 			&models.Reaction{},
+			&models.NotificationOutbox{},
+			&models.PollOptionTally{},
+			&models.RealmEmoji{},
+			&models.PostTranslation{},
+			&models.InsightUsage{},
 		)...,
 	); err != nil {
 		return err
 	}
 
+	if backfillFollower {
+		if err := source.Exec(`
+			UPDATE publishers SET total_follower = (
+				SELECT COUNT(s.id) FROM subscriptions s
+				WHERE s.account_id = publishers.id AND s.deleted_at IS NULL
+			)
+		`).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateReactionTarget will fill the target of the reactions created before the polymorphic target existed.
+// The rows are updated in small batches and the index is created concurrently, so the table will not be locked for long.
+// It is safe to run multiple times, the rows created by the older instances during the rolling update will be filled next time.
+func migrateReactionTarget(source *gorm.DB) error {
+	if !source.Migrator().HasTable(&models.Reaction{}) {
+		return nil
+	}
+
+	for _, field := range []string{"TargetType", "TargetID", "TargetKey"} {
+		if !source.Migrator().HasColumn(&models.Reaction{}, field) {
+			if err := source.Migrator().AddColumn(&models.Reaction{}, field); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		tx := source.Exec(`
+			UPDATE reactions SET target_type = ?, target_id = post_id, target_key = ''
+			WHERE id IN (
+				SELECT id FROM reactions
+				WHERE (target_type IS NULL OR target_type = '') AND post_id IS NOT NULL
+				LIMIT 5000
+			)
+		`, models.ReactionTargetPost)
+		if tx.Error != nil {
+			return tx.Error
+		} else if tx.RowsAffected == 0 {
+			break
+		}
+	}
+
+	return source.Exec(`
+		CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS idx_reaction_target
+		ON reactions (target_type, target_id, target_key, account_id, symbol)
+	`).Error
+}
+
+// migratePostLanguage will replace the language names stored before with the ISO 639-1 codes,
+// both in the posts and the preferred languages of the users.
+// The posts were checked first, so the table is only rewritten when there are names left,
+// the check is cheap with the index on the language once the first migration was done.
+func migratePostLanguage(source *gorm.DB) error {
+	var names []string
+	var values []string
+	var args []any
+	for _, lang := range lingua.AllLanguages() {
+		names = append(names, strings.ToLower(lang.String()))
+		values = append(values, "(?, ?)")
+		args = append(args, strings.ToLower(lang.String()), strings.ToLower(lang.IsoCode639_1().String()))
+	}
+	mapping := "(VALUES " + strings.Join(values, ", ") + ") AS m(name, code)"
+
+	var remaining bool
+	if source.Migrator().HasTable(&models.Post{}) {
+		if err := source.Raw("SELECT EXISTS (SELECT 1 FROM posts WHERE language IN ?)", names).
+			Scan(&remaining).Error; err != nil {
+			return err
+		}
+	}
+
+	if remaining {
+		if err := source.Exec(
+			"UPDATE posts SET language = m.code FROM "+mapping+" WHERE posts.language = m.name",
+			args...,
+		).Error; err != nil {
+			return err
+		}
+	}
+
+	if source.Migrator().HasTable(&models.AccountPreference{}) {
+		// The order of the languages is kept, and the duplicated ones after mapping only keep the first
+		if err := source.Exec(`
+			UPDATE account_preferences SET languages = (
+				SELECT COALESCE(jsonb_agg(l.code ORDER BY l.idx), '[]'::jsonb) FROM (
+					SELECT COALESCE(m.code, LOWER(e.value)) AS code, MIN(e.idx) AS idx
+					FROM jsonb_array_elements_text(account_preferences.languages) WITH ORDINALITY AS e(value, idx)
+					LEFT JOIN `+mapping+` ON m.name = LOWER(e.value)
+					GROUP BY 1
+				) AS l
+			)
+			WHERE jsonb_typeof(languages) = 'array' AND EXISTS (
+				SELECT 1 FROM jsonb_array_elements_text(languages) AS e(value)
+				WHERE LENGTH(e.value) > 2 AND LOWER(e.value) <> 'unknown'
+			)
+		`, args...).Error; err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
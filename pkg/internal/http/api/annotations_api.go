@@ -0,0 +1,145 @@
+package api
+
+import (
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func getAnnotatableArticle(c *fiber.Ctx) (models.Post, error) {
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, exts.GetAuthenticatedUser(c))
+
+	var item models.Post
+	if err := tx.
+		Where("id = ? AND type = ?", c.Params("postId"), models.PostTypeArticle).
+		Preload("Publisher").
+		First(&item).Error; err != nil {
+		return item, fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	return item, nil
+}
+
+func listAnnotations(c *fiber.Ctx) error {
+	take := c.QueryInt("take", 0)
+	offset := c.QueryInt("offset", 0)
+
+	post, err := getAnnotatableArticle(c)
+	if err != nil {
+		return err
+	}
+
+	tx := database.C.Where("post_id = ?", post.ID)
+	tx = services.FilterAnnotationWithUserContext(tx, post, exts.GetAuthenticatedUser(c))
+	if len(c.Query("resolved")) > 0 {
+		if c.QueryBool("resolved") {
+			tx = tx.Where("resolved_at IS NOT NULL")
+		} else {
+			tx = tx.Where("resolved_at IS NULL")
+		}
+	}
+
+	countTx := tx
+	count, err := services.CountAnnotation(countTx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	items, err := services.ListAnnotation(tx, take, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(fiber.Map{
+		"count": count,
+		"data":  items,
+	})
+}
+
+func createAnnotation(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var data struct {
+		StartOffset int                         `json:"start_offset" validate:"min=0"`
+		EndOffset   int                         `json:"end_offset" validate:"min=1"`
+		Content     string                      `json:"content" validate:"max=4096"`
+		Visibility  models.AnnotationVisibility `json:"visibility" validate:"min=0,max=2"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	post, err := getAnnotatableArticle(c)
+	if err != nil {
+		return err
+	}
+
+	item, err := services.NewAnnotation(user, post, models.Annotation{
+		StartOffset: data.StartOffset,
+		EndOffset:   data.EndOffset,
+		Content:     data.Content,
+		Visibility:  data.Visibility,
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(item)
+}
+
+func resolveAnnotation(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	post, err := getAnnotatableArticle(c)
+	if err != nil {
+		return err
+	}
+
+	var item models.Annotation
+	if err := database.C.Where("id = ? AND post_id = ?", c.Params("annotationId"), post.ID).First(&item).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	isPublisher := post.Publisher.AccountID != nil && *post.Publisher.AccountID == user.ID
+	if item.AccountID != user.ID && !(isPublisher && item.Visibility != models.AnnotationVisibilityPrivate) {
+		return fiber.NewError(fiber.StatusForbidden, "only the annotator or the publisher can resolve this annotation")
+	}
+
+	if item, err = services.ResolveAnnotation(item); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(item)
+}
+
+func deleteAnnotation(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var item models.Annotation
+	if err := database.C.
+		Where("id = ? AND post_id = ? AND account_id = ?", c.Params("annotationId"), c.Params("postId"), user.ID).
+		First(&item).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	if err := services.DeleteAnnotation(item); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
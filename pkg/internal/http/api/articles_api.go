@@ -19,6 +19,22 @@ import (
 	"github.com/samber/lo"
 )
 
+// resolveArticleContent will keep the markdown content and the blocks in sync.
+// When the blocks were provided, the content will be generated from them, otherwise the blocks will be parsed from the content.
+func resolveArticleContent(content *string, blocks *[]models.ArticleBlock, attachments *[]string) error {
+	if len(*blocks) == 0 {
+		*blocks = services.ParseArticleBlocks(*content)
+		return nil
+	}
+
+	if err := services.ValidateArticleBlocks(*blocks); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	*content = services.RenderArticleBlocks(*blocks)
+	*attachments = lo.Uniq(append(*attachments, services.ListArticleBlockAttachment(*blocks)...))
+	return nil
+}
+
 func createArticle(c *fiber.Ctx) error {
 	if err := sec.EnsureGrantedPerm(c, "CreatePosts", true); err != nil {
 		return err
@@ -26,21 +42,24 @@ func createArticle(c *fiber.Ctx) error {
 	user := c.Locals("user").(authm.Account)
 
 	var data struct {
-		Publisher      uint              `json:"publisher"`
-		Alias          *string           `json:"alias"`
-		Title          string            `json:"title" validate:"required,max=1024"`
-		Description    *string           `json:"description"`
-		Content        string            `json:"content" validate:"required"`
-		Thumbnail      *string           `json:"thumbnail"`
-		Attachments    []string          `json:"attachments"`
-		Tags           []models.Tag      `json:"tags"`
-		Categories     []models.Category `json:"categories"`
-		PublishedAt    *time.Time        `json:"published_at"`
-		PublishedUntil *time.Time        `json:"published_until"`
-		VisibleUsers   []uint            `json:"visible_users_list"`
-		InvisibleUsers []uint            `json:"invisible_users_list"`
-		Visibility     *int8             `json:"visibility"`
-		IsDraft        bool              `json:"is_draft"`
+		Publisher      uint                  `json:"publisher"`
+		Alias          *string               `json:"alias"`
+		Title          string                `json:"title" validate:"required,max=1024"`
+		Description    *string               `json:"description"`
+		Content        string                `json:"content" validate:"required_without=Blocks"`
+		Blocks         []models.ArticleBlock `json:"blocks"`
+		Thumbnail      *string               `json:"thumbnail"`
+		Attachments    []string              `json:"attachments"`
+		Tags           []models.Tag          `json:"tags"`
+		Categories     []models.Category     `json:"categories"`
+		Series         *uint                 `json:"series"`
+		PublishedAt    *time.Time            `json:"published_at"`
+		PublishedUntil *time.Time            `json:"published_until"`
+		VisibleUsers   []uint                `json:"visible_users_list"`
+		InvisibleUsers []uint                `json:"invisible_users_list"`
+		Visibility     *int8                 `json:"visibility"`
+		Language       *string               `json:"language" validate:"omitempty,len=2"`
+		IsDraft        bool                  `json:"is_draft"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
@@ -52,11 +71,16 @@ func createArticle(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
+	if err := resolveArticleContent(&data.Content, &data.Blocks, &data.Attachments); err != nil {
+		return err
+	}
+
 	body := models.PostArticleBody{
 		Thumbnail:   data.Thumbnail,
 		Title:       data.Title,
 		Description: data.Description,
 		Content:     data.Content,
+		Blocks:      data.Blocks,
 		Attachments: data.Attachments,
 	}
 
@@ -68,7 +92,6 @@ func createArticle(c *fiber.Ctx) error {
 		Alias:          data.Alias,
 		Type:           models.PostTypeArticle,
 		Body:           bodyMapping,
-		Language:       services.DetectLanguage(data.Content),
 		Tags:           data.Tags,
 		Categories:     data.Categories,
 		IsDraft:        data.IsDraft,
@@ -78,11 +101,21 @@ func createArticle(c *fiber.Ctx) error {
 		InvisibleUsers: data.InvisibleUsers,
 		PublisherID:    publisher.ID,
 	}
+	applyPostLanguage(&item, data.Language)
 
 	if item.PublishedAt == nil {
 		item.PublishedAt = lo.ToPtr(time.Now())
 	}
 
+	if data.Series != nil {
+		series, err := services.GetSeriesWithPublisher(*data.Series, publisher)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		item.SeriesID = &series.ID
+		item.SeriesOrder = services.GetSeriesNextOrder(series)
+	}
+
 	if data.Visibility != nil {
 		item.Visibility = *data.Visibility
 	} else {
@@ -112,21 +145,24 @@ func editArticle(c *fiber.Ctx) error {
 	user := c.Locals("user").(authm.Account)
 
 	var data struct {
-		Publisher      uint              `json:"publisher"`
-		Alias          *string           `json:"alias"`
-		Title          string            `json:"title" validate:"required,max=1024"`
-		Description    *string           `json:"description"`
-		Content        string            `json:"content" validate:"required"`
-		Thumbnail      *string           `json:"thumbnail"`
-		Attachments    []string          `json:"attachments"`
-		Tags           []models.Tag      `json:"tags"`
-		Categories     []models.Category `json:"categories"`
-		PublishedAt    *time.Time        `json:"published_at"`
-		PublishedUntil *time.Time        `json:"published_until"`
-		VisibleUsers   []uint            `json:"visible_users_list"`
-		InvisibleUsers []uint            `json:"invisible_users_list"`
-		Visibility     *int8             `json:"visibility"`
-		IsDraft        bool              `json:"is_draft"`
+		Publisher      uint                  `json:"publisher"`
+		Alias          *string               `json:"alias"`
+		Title          string                `json:"title" validate:"required,max=1024"`
+		Description    *string               `json:"description"`
+		Content        string                `json:"content" validate:"required_without=Blocks"`
+		Blocks         []models.ArticleBlock `json:"blocks"`
+		Thumbnail      *string               `json:"thumbnail"`
+		Attachments    []string              `json:"attachments"`
+		Tags           []models.Tag          `json:"tags"`
+		Categories     []models.Category     `json:"categories"`
+		Series         *uint                 `json:"series"`
+		PublishedAt    *time.Time            `json:"published_at"`
+		PublishedUntil *time.Time            `json:"published_until"`
+		VisibleUsers   []uint                `json:"visible_users_list"`
+		InvisibleUsers []uint                `json:"invisible_users_list"`
+		Visibility     *int8                 `json:"visibility"`
+		Language       *string               `json:"language" validate:"omitempty,len=2"`
+		IsDraft        bool                  `json:"is_draft"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
@@ -161,11 +197,16 @@ func editArticle(c *fiber.Ctx) error {
 		item.PublishedAt = data.PublishedAt
 	}
 
+	if err := resolveArticleContent(&data.Content, &data.Blocks, &data.Attachments); err != nil {
+		return err
+	}
+
 	body := models.PostArticleBody{
 		Thumbnail:   data.Thumbnail,
 		Title:       data.Title,
 		Description: data.Description,
 		Content:     data.Content,
+		Blocks:      data.Blocks,
 		Attachments: data.Attachments,
 	}
 
@@ -175,7 +216,7 @@ func editArticle(c *fiber.Ctx) error {
 
 	item.Alias = data.Alias
 	item.Body = bodyMapping
-	item.Language = services.DetectLanguage(data.Content)
+	applyPostLanguage(&item, data.Language)
 	item.Tags = data.Tags
 	item.Categories = data.Categories
 	item.IsDraft = data.IsDraft
@@ -183,6 +224,19 @@ func editArticle(c *fiber.Ctx) error {
 	item.VisibleUsers = data.VisibleUsers
 	item.InvisibleUsers = data.InvisibleUsers
 
+	// The series is kept when the field is absent, zero removes the article from its series
+	if data.Series != nil && *data.Series == 0 {
+		item.SeriesID = nil
+		item.SeriesOrder = 0
+	} else if data.Series != nil && (item.SeriesID == nil || *item.SeriesID != *data.Series) {
+		series, err := services.GetSeriesWithPublisher(*data.Series, publisher)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		item.SeriesID = &series.ID
+		item.SeriesOrder = services.GetSeriesNextOrder(series)
+	}
+
 	// Preload publisher data
 	item.Publisher = publisher
 
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+	"github.com/spf13/viper"
+)
+
+const (
+	embedDefaultWidth  = 480
+	embedDefaultHeight = 320
+)
+
+var embedCardTemplate = template.Must(template.New("card").Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{margin:0;font-family:system-ui,-apple-system,"Segoe UI",sans-serif;color:#1f1f1f;background:#fff}
+.card{box-sizing:border-box;border:1px solid #e0e0e0;border-radius:12px;padding:16px;max-width:100%;height:100vh;display:flex;flex-direction:column;gap:12px;overflow:hidden}
+.publisher{display:flex;align-items:center;gap:10px;text-decoration:none;color:inherit}
+.publisher img{width:40px;height:40px;border-radius:50%;object-fit:cover;background:#eee}
+.publisher .nick{font-weight:600}
+.publisher .name{color:#757575;font-size:.875em}
+.title{margin:0;font-size:1.125em}
+.content{flex:1;margin:0;white-space:pre-wrap;word-break:break-word;overflow:hidden}
+.footer{display:flex;justify-content:space-between;align-items:center;color:#757575;font-size:.875em}
+.footer a{color:inherit}
+.reactions span{margin-right:8px}
+</style>
+</head>
+<body>
+<div class="card">
+<a class="publisher" href="{{.PublisherURL}}" target="_blank" rel="noopener">
+{{if .Avatar}}<img src="{{.Avatar}}" alt="">{{end}}
+<div><div class="nick">{{.Publisher.Nick}}</div><div class="name">@{{.Publisher.Name}}</div></div>
+</a>
+{{if .Title}}<h1 class="title">{{.Title}}</h1>{{end}}
+<p class="content">{{.Content}}</p>
+<div class="footer">
+<div class="reactions">{{range .Reactions}}<span>{{.Symbol}} {{.Count}}</span>{{end}}<span>💬 {{.ReplyCount}}</span></div>
+<a href="{{.PostURL}}" target="_blank" rel="noopener">{{.PublishedAt}}</a>
+</div>
+</div>
+</body>
+</html>
+`))
+
+// getPublicPost will find the post that can be embedded, the post must be published and visible to everyone.
+func getPublicPost(id string) (models.Post, error) {
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, nil)
+	return services.GetPostByIdentifier(tx, id)
+}
+
+func getEmbedPostURL(item models.Post) string {
+	id := fmt.Sprint(item.ID)
+	if item.Alias != nil && item.AliasPrefix != nil {
+		id = *item.AliasPrefix + ":" + *item.Alias
+	}
+	return strings.TrimSuffix(viper.GetString("embed.base_url"), "/") + "/posts/" + id
+}
+
+func getEmbedCardURL(item models.Post) string {
+	return strings.TrimSuffix(viper.GetString("embed.api_url"), "/") + "/posts/" + fmt.Sprint(item.ID) + "/embed"
+}
+
+func getOEmbed(c *fiber.Ctx) error {
+	if format := c.Query("format", "json"); format != "json" {
+		return fiber.NewError(fiber.StatusNotImplemented, "only json format is supported")
+	}
+
+	uri, err := url.Parse(c.Query("url"))
+	if err != nil || len(uri.Path) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "url is required")
+	}
+	if base, err := url.Parse(viper.GetString("embed.base_url")); err != nil || !strings.EqualFold(uri.Host, base.Host) {
+		return fiber.NewError(fiber.StatusNotFound, "url is not provided by this site")
+	}
+	segments := strings.Split(strings.Trim(uri.Path, "/"), "/")
+	if len(segments) < 2 || segments[len(segments)-2] != "posts" {
+		return fiber.NewError(fiber.StatusNotFound, "url is not a post")
+	}
+
+	item, err := getPublicPost(segments[len(segments)-1])
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	width := embedDefaultWidth
+	height := embedDefaultHeight
+	if maxWidth := c.QueryInt("maxwidth", 0); maxWidth > 0 {
+		width = min(width, maxWidth)
+	}
+	if maxHeight := c.QueryInt("maxheight", 0); maxHeight > 0 {
+		height = min(height, maxHeight)
+	}
+
+	title, _ := item.Body["title"].(string)
+	if len(title) == 0 {
+		title = fmt.Sprintf("Post by %s", item.Publisher.Nick)
+	}
+
+	return c.JSON(fiber.Map{
+		"version":       "1.0",
+		"type":          "rich",
+		"provider_name": "Solar Network",
+		"provider_url":  viper.GetString("embed.base_url"),
+		"title":         title,
+		"author_name":   item.Publisher.Nick,
+		"author_url":    strings.TrimSuffix(viper.GetString("embed.base_url"), "/") + "/publishers/" + item.Publisher.Name,
+		"cache_age":     300,
+		"width":         width,
+		"height":        height,
+		"html": fmt.Sprintf(
+			`<iframe src="%s" width="%d" height="%d" style="border:none;max-width:100%%" loading="lazy" sandbox="allow-popups allow-popups-to-escape-sandbox"></iframe>`,
+			template.HTMLEscapeString(getEmbedCardURL(item)), width, height,
+		),
+	})
+}
+
+func getPostEmbedCard(c *fiber.Ctx) error {
+	item, err := getPublicPost(c.Params("postId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	reactions, err := services.ListReactions(database.C.Where("post_id = ?", item.ID))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	type reactionCount struct {
+		Symbol string
+		Count  int64
+	}
+	reactionList := lo.MapToSlice(reactions, func(symbol string, count int64) reactionCount {
+		if emoji, ok := services.BuiltinReactionEmojis[symbol]; ok {
+			symbol = emoji.Icon
+		}
+		return reactionCount{Symbol: symbol, Count: count}
+	})
+	sort.Slice(reactionList, func(i, j int) bool {
+		return reactionList[i].Count > reactionList[j].Count
+	})
+
+	title, _ := item.Body["title"].(string)
+	content, _ := item.Body["content"].(string)
+	content = services.RenderMarkdownPlainText(content)
+	if len([]rune(content)) > services.TruncatePostContentThreshold {
+		content = string([]rune(content)[:services.TruncatePostContentThreshold]) + "..."
+	}
+
+	avatar := item.Publisher.Avatar
+	if len(avatar) > 0 && !strings.HasPrefix(avatar, "http://") && !strings.HasPrefix(avatar, "https://") {
+		avatar = strings.TrimSuffix(viper.GetString("embed.attachment_url"), "/") + "/" + avatar
+	}
+
+	var buf bytes.Buffer
+	if err := embedCardTemplate.Execute(&buf, map[string]any{
+		"Language":     lo.Ternary(len(item.Language) == 2, item.Language, "en"),
+		"Title":        title,
+		"Content":      content,
+		"Avatar":       avatar,
+		"Publisher":    item.Publisher,
+		"PublisherURL": strings.TrimSuffix(viper.GetString("embed.base_url"), "/") + "/publishers/" + item.Publisher.Name,
+		"PostURL":      getEmbedPostURL(item),
+		"PublishedAt":  lo.FromPtr(item.PublishedAt).Format("2006-01-02"),
+		"Reactions":    lo.Slice(reactionList, 0, 5),
+		"ReplyCount":   services.CountPostReply(item.ID),
+	}); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; img-src https: data:; style-src 'unsafe-inline'; frame-ancestors *")
+	return c.Send(buf.Bytes())
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	"git.solsynth.dev/hypernet/passport/pkg/authkit"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+)
+
+func listReactionEmojis(c *fiber.Ctx) error {
+	emojis := services.ListBuiltinReactionEmoji()
+
+	if len(c.Query("realm")) > 0 {
+		realm, err := authkit.GetRealmByAlias(gap.Nx, c.Query("realm"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to get realm: %v", err))
+		}
+
+		custom, err := services.ListRealmEmoji(realm.ID)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+		emojis = append(emojis, lo.Map(custom, func(item models.RealmEmoji, index int) models.ReactionEmoji {
+			return models.ReactionEmoji{
+				Symbol:     services.GetRealmEmojiSymbol(item),
+				Attachment: item.Attachment,
+				Attitude:   item.Attitude,
+			}
+		})...)
+	}
+
+	return c.JSON(emojis)
+}
+
+func listRealmEmojis(c *fiber.Ctx) error {
+	realm, err := authkit.GetRealmByAlias(gap.Nx, c.Params("realm"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to get realm: %v", err))
+	}
+
+	emojis, err := services.ListRealmEmoji(realm.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(emojis)
+}
+
+func createRealmEmoji(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var data struct {
+		Alias      string                  `json:"alias" validate:"required,min=2,max=32,alphanumunicode"`
+		Name       string                  `json:"name" validate:"required,max=64"`
+		Attachment string                  `json:"attachment" validate:"required"`
+		Attitude   models.ReactionAttitude `json:"attitude" validate:"max=2"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	realm, err := authkit.GetRealmByAlias(gap.Nx, c.Params("realm"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to get realm: %v", err))
+	}
+	if !authkit.CheckRealmMemberPerm(gap.Nx, realm.ID, int(user.ID), 100) {
+		return fiber.NewError(fiber.StatusForbidden, "you least need to be the admin of this realm to add emoji")
+	}
+
+	emoji, err := services.NewRealmEmoji(models.RealmEmoji{
+		Alias:      data.Alias,
+		Name:       data.Name,
+		Attachment: data.Attachment,
+		Attitude:   data.Attitude,
+		RealmID:    realm.ID,
+		AccountID:  user.ID,
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(emoji)
+}
+
+func deleteRealmEmoji(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	realm, err := authkit.GetRealmByAlias(gap.Nx, c.Params("realm"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to get realm: %v", err))
+	}
+	if !authkit.CheckRealmMemberPerm(gap.Nx, realm.ID, int(user.ID), 100) {
+		return fiber.NewError(fiber.StatusForbidden, "you least need to be the admin of this realm to delete emoji")
+	}
+
+	var emoji models.RealmEmoji
+	if err := database.C.Where("realm_id = ? AND alias = ?", realm.ID, c.Params("alias")).First(&emoji).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	if err := services.DeleteRealmEmoji(emoji); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(emoji)
+}
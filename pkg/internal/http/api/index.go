@@ -14,6 +14,8 @@ func MapAPIs(app *fiber.App, baseURL string) {
 			publishers.Post("/personal", createPersonalPublisher)
 			publishers.Post("/organization", createOrganizationPublisher)
 			publishers.Get("/:name/pins", listPinnedPost)
+			publishers.Get("/:name/stats", getPublisherStats)
+			publishers.Get("/:name/followers", listPublisherFollowers)
 			publishers.Get("/:name", getPublisher)
 			publishers.Put("/:name", editPublisher)
 			publishers.Delete("/:name", deletePublisher)
@@ -54,9 +56,21 @@ func MapAPIs(app *fiber.App, baseURL string) {
 			posts.Get("/search", searchPost)
 			posts.Get("/minimal", listPostMinimal)
 			posts.Get("/drafts", listDraftPost)
+			posts.Post("/suggest-metadata", suggestPostMetadata)
 			posts.Get("/:postId", getPost)
+			posts.Get("/:postId/embed", getPostEmbedCard)
 			posts.Get("/:postId/insight", getPostInsight)
+			posts.Post("/:postId/insight", regeneratePostInsight)
+			posts.Get("/:postId/insight/stream", streamPostInsight)
+			posts.Get("/:postId/translate", getPostTranslation)
+			posts.Get("/:postId/reactions", listPostReactions)
 			posts.Post("/:postId/react", reactPost)
+			posts.Get("/:postId/anchors/reactions", listPostAnchorReactions)
+			posts.Post("/:postId/anchors/:anchor/react", reactPostAnchor)
+			posts.Get("/:postId/annotations", listAnnotations)
+			posts.Post("/:postId/annotations", createAnnotation)
+			posts.Put("/:postId/annotations/:annotationId/resolve", resolveAnnotation)
+			posts.Delete("/:postId/annotations/:annotationId", deleteAnnotation)
 			posts.Post("/:postId/pin", pinPost)
 			posts.Delete("/:postId", deletePost)
 
@@ -70,21 +84,38 @@ func MapAPIs(app *fiber.App, baseURL string) {
 			polls.Post("/", createPoll)
 			polls.Put("/:pollId", updatePoll)
 			polls.Delete("/:pollId", deletePoll)
+			polls.Post("/:pollId/close", closePoll)
 			polls.Post("/:pollId/answer", answerPoll)
 			polls.Get("/:pollId/answer", getMyPollAnswer)
+			polls.Delete("/:pollId/answer", retractPollAnswer)
+			polls.Get("/:pollId/answers", listPollAnswers)
+			polls.Post("/:pollId/options/:optionId/react", reactPollOption)
+		}
+
+		reactions := api.Group("/reactions").Name("Reactions API")
+		{
+			reactions.Get("/emojis", listReactionEmojis)
+			reactions.Get("/emojis/:realm", listRealmEmojis)
+			reactions.Post("/emojis/:realm", createRealmEmoji)
+			reactions.Delete("/emojis/:realm/:alias", deleteRealmEmoji)
 		}
 
 		subscriptions := api.Group("/subscriptions").Name("Subscriptions API")
 		{
+			subscriptions.Get("/", listSubscriptions)
 			subscriptions.Get("/users/:userId", getSubscriptionOnUser)
 			subscriptions.Get("/tags/:tagId", getSubscriptionOnTag)
 			subscriptions.Get("/categories/:categoryId", getSubscriptionOnCategory)
+			subscriptions.Get("/series/:seriesId", getSubscriptionOnSeries)
 			subscriptions.Post("/users/:userId", subscribeToUser)
 			subscriptions.Post("/tags/:tagId", subscribeToTag)
 			subscriptions.Post("/categories/:categoryId", subscribeToCategory)
+			subscriptions.Post("/series/:seriesId", subscribeToSeries)
 			subscriptions.Delete("/users/:userId", unsubscribeFromUser)
 			subscriptions.Delete("/tags/:tagId", unsubscribeFromTag)
 			subscriptions.Delete("/categories/:categoryId", unsubscribeFromCategory)
+			subscriptions.Delete("/series/:seriesId", unsubscribeFromSeries)
+			subscriptions.Put("/:subscriptionId", editSubscription)
 		}
 
 		api.Get("/categories", listCategories)
@@ -93,9 +124,31 @@ func MapAPIs(app *fiber.App, baseURL string) {
 		api.Put("/categories/:categoryId", editCategory)
 		api.Delete("/categories/:categoryId", deleteCategory)
 
+		series := api.Group("/series").Name("Series API")
+		{
+			series.Get("/", listSeries)
+			series.Get("/:seriesId", getSeries)
+			series.Post("/", createSeries)
+			series.Put("/:seriesId", editSeries)
+			series.Put("/:seriesId/order", reorderSeries)
+			series.Delete("/:seriesId", deleteSeries)
+		}
+
 		api.Get("/tags", listTags)
 		api.Get("/tags/:tag", getTag)
 
+		outbox := api.Group("/outbox").Name("Notification Outbox API")
+		{
+			outbox.Get("/metrics", getOutboxMetrics)
+		}
+
+		preferences := api.Group("/preferences").Name("Preferences API")
+		{
+			preferences.Get("/", getPreference)
+			preferences.Put("/languages", updatePreferredLanguages)
+		}
+
 		api.Get("/whats-new", getWhatsNew)
+		api.Get("/oembed", getOEmbed)
 	}
 }
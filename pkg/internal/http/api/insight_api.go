@@ -1,8 +1,11 @@
 package api
 
 import (
-	"strconv"
-	"strings"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
@@ -12,47 +15,128 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func getPostInsight(c *fiber.Ctx) error {
+func getInsightPost(c *fiber.Ctx, user authm.Account) (models.Post, error) {
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, &user)
+
+	item, err := services.GetPostByIdentifier(tx, c.Params("postId"))
+	if err != nil {
+		return item, fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+	return item, nil
+}
+
+func enqueuePostInsight(c *fiber.Ctx, regenerate bool) (models.PostInsight, error) {
 	if err := sec.EnsureAuthenticated(c); err != nil {
-		return err
+		return models.PostInsight{}, err
 	}
 	user := c.Locals("user").(authm.Account)
 
-	id := c.Params("postId")
+	item, err := getInsightPost(c, user)
+	if err != nil {
+		return models.PostInsight{}, err
+	}
 
-	var item models.Post
-	var err error
+	insight, err := services.EnqueuePostInsight(item, user.ID, regenerate)
+	if errors.Is(err, services.ErrInsightRateLimited) {
+		return insight, fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	} else if err != nil {
+		return insight, fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
 
-	tx := services.FilterPostDraft(database.C)
+	return insight, nil
+}
 
-	if user, authenticated := c.Locals("user").(authm.Account); authenticated {
-		tx = services.FilterPostWithUserContext(tx, &user)
-	} else {
-		tx = services.FilterPostWithUserContext(tx, nil)
+func getPostInsight(c *fiber.Ctx) error {
+	insight, err := enqueuePostInsight(c, false)
+	if err != nil {
+		return err
 	}
 
-	if numericId, paramErr := strconv.Atoi(id); paramErr == nil {
-		item, err = services.GetPost(tx, uint(numericId))
-	} else {
-		segments := strings.Split(id, ":")
-		if len(segments) != 2 {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid post id, must be a number or a string with two segment divided by a colon")
-		}
-		area := segments[0]
-		alias := segments[1]
-		item, err = services.GetPostByAlias(tx, alias, area)
+	if insight.Status == models.PostInsightPending {
+		c.Status(fiber.StatusAccepted)
 	}
+	return c.JSON(insight)
+}
 
+func regeneratePostInsight(c *fiber.Ctx) error {
+	insight, err := enqueuePostInsight(c, true)
 	if err != nil {
-		return fiber.NewError(fiber.StatusNotFound, err.Error())
+		return err
 	}
 
-	response, err := services.GeneratePostInsights(item, user.ID)
+	return c.Status(fiber.StatusAccepted).JSON(insight)
+}
+
+func writeInsightEvent(w *bufio.Writer, event string, data any) error {
+	raw, _ := json.Marshal(data)
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, raw); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// streamPostInsight will send the insight job as server-sent events.
+// The partial output was sent as chunk events when the job is running in this instance,
+// otherwise the job was polled until it is finished. The final insight was sent as a done or failed event.
+func streamPostInsight(c *fiber.Ctx) error {
+	insight, err := enqueuePostInsight(c, false)
 	if err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		return err
 	}
 
-	return c.JSON(fiber.Map{
-		"response": response,
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		writeInsightStream(w, insight, func(insight *models.PostInsight) error {
+			return database.C.Where("id = ?", insight.ID).First(insight).Error
+		})
 	})
+
+	return nil
+}
+
+// writeInsightStream will write the events of the insight job until it is finished.
+// The reload is used to poll the job when it is not running in this instance.
+func writeInsightStream(w *bufio.Writer, insight models.PostInsight, reload func(*models.PostInsight) error) {
+	if err := writeInsightEvent(w, "status", insight); err != nil {
+		return
+	}
+
+	if insight.Status == models.PostInsightPending {
+		if partial, chunks, ok := services.SubscribePostInsight(insight.ID); ok {
+			if len(partial) > 0 {
+				if err := writeInsightEvent(w, "chunk", fiber.Map{"text": partial}); err != nil {
+					return
+				}
+			}
+			for chunk := range chunks {
+				if err := writeInsightEvent(w, "chunk", fiber.Map{"text": chunk}); err != nil {
+					return
+				}
+			}
+		}
+
+		deadline := time.Now().Add(services.InsightJobTimeout + time.Minute)
+		for time.Now().Before(deadline) {
+			if err := reload(&insight); err != nil {
+				break
+			} else if insight.Status != models.PostInsightPending {
+				break
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || w.Flush() != nil {
+				return
+			}
+			time.Sleep(time.Second)
+		}
+	}
+
+	event := "done"
+	if insight.Status != models.PostInsightDone {
+		event = "failed"
+	}
+	_ = writeInsightEvent(w, event, insight)
 }
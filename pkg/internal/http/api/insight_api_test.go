@@ -0,0 +1,57 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+)
+
+func TestWriteInsightStreamPolling(t *testing.T) {
+	insight := models.PostInsight{Status: models.PostInsightPending}
+	insight.ID = 999999
+
+	var polls int
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeInsightStream(w, insight, func(item *models.PostInsight) error {
+		polls++
+		if polls > 1 {
+			item.Status = models.PostInsightDone
+			item.Response = "summary"
+		}
+		return nil
+	})
+
+	out := buf.String()
+	for _, expected := range []string{
+		"event: status\ndata: ",
+		": ping\n\n",
+		"event: done\ndata: ",
+		`"response":"summary"`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the stream, got %q", expected, out)
+		}
+	}
+	if strings.Index(out, "event: status") > strings.Index(out, "event: done") {
+		t.Errorf("expected the status event first, got %q", out)
+	}
+}
+
+func TestWriteInsightStreamFinished(t *testing.T) {
+	insight := models.PostInsight{Status: models.PostInsightFailed, Error: "model overloaded"}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeInsightStream(w, insight, func(item *models.PostInsight) error {
+		t.Error("expected the finished insight not to be polled")
+		return nil
+	})
+
+	if out := buf.String(); !strings.Contains(out, "event: failed\ndata: ") || strings.Contains(out, "ping") {
+		t.Errorf("unexpected stream %q", out)
+	}
+}
@@ -0,0 +1,16 @@
+package api
+
+import (
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	"github.com/gofiber/fiber/v2"
+)
+
+func getOutboxMetrics(c *fiber.Ctx) error {
+	if err := sec.EnsureGrantedPerm(c, "ManageNotificationOutbox", true); err != nil {
+		return err
+	}
+
+	return c.JSON(services.GetOutboxMetrics(database.C))
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"time"
+	"fmt"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
@@ -10,6 +10,7 @@ import (
 	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
 	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
 )
 
 func getMyPollAnswer(c *fiber.Ctx) error {
@@ -28,6 +29,81 @@ func getMyPollAnswer(c *fiber.Ctx) error {
 	return c.JSON(answer)
 }
 
+// getVisiblePoll will find the poll and the post it belongs to, the poll is not found when the user cannot see the post.
+// The poll may not belong to any post, the returned post is empty then.
+func getVisiblePoll(pollId int, user authm.Account) (models.Poll, models.Post, error) {
+	var poll models.Poll
+	if err := database.C.Where("id = ?", pollId).First(&poll).Error; err != nil {
+		return poll, models.Post{}, fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	var count int64
+	database.C.Model(&models.Post{}).Where("poll_id = ?", poll.ID).Count(&count)
+	if count == 0 {
+		return poll, models.Post{}, nil
+	}
+
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, &user)
+
+	var op models.Post
+	if err := tx.Where("poll_id = ?", poll.ID).Preload("Publisher").First(&op).Error; err != nil {
+		return poll, op, fiber.NewError(fiber.StatusNotFound, "poll was not found")
+	}
+
+	return poll, op, nil
+}
+
+func listPollAnswers(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	take := c.QueryInt("take", 0)
+	offset := c.QueryInt("offset", 0)
+	pollId, _ := c.ParamsInt("pollId")
+
+	if take > 100 {
+		take = 100
+	}
+
+	poll, _, err := getVisiblePoll(pollId, user)
+	if err != nil {
+		return err
+	}
+
+	if poll.IsAnonymous {
+		return fiber.NewError(fiber.StatusForbidden, "this poll is anonymous")
+	} else if !poll.IsVoterPublic && poll.AccountID != user.ID {
+		return fiber.NewError(fiber.StatusForbidden, "the voters of this poll are only visible to its owner")
+	}
+
+	if services.ShouldHidePollResult(poll, &user.ID, services.HasAnsweredPoll(poll, user.ID)) {
+		return fiber.NewError(fiber.StatusForbidden, "the result of this poll is hidden for now")
+	}
+
+	tx := database.C.Where("poll_id = ?", poll.ID)
+	if len(c.Query("option")) > 0 {
+		tx = tx.Where("answer = ? OR answers @> ?", c.Query("option"), fmt.Sprintf("[%q]", c.Query("option")))
+	}
+
+	var count int64
+	if err := tx.Model(&models.PollAnswer{}).Count(&count).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	var answers []models.PollAnswer
+	if err := tx.Limit(take).Offset(offset).Order("created_at DESC").Find(&answers).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(fiber.Map{
+		"count": count,
+		"data":  answers,
+	})
+}
+
 func answerPoll(c *fiber.Ctx) error {
 	pollId, _ := c.ParamsInt("pollId")
 
@@ -37,35 +113,32 @@ func answerPoll(c *fiber.Ctx) error {
 	user := c.Locals("user").(authm.Account)
 
 	var data struct {
-		Answer string `json:"answer" validate:"required"`
+		Answer  string   `json:"answer"`
+		Answers []string `json:"answers"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
 		return err
 	}
+	if len(data.Answers) == 0 && len(data.Answer) > 0 {
+		data.Answers = []string{data.Answer}
+	}
 
 	var poll models.Poll
 	if err := database.C.Where("id = ?", pollId).First(&poll).Error; err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
-	if poll.ExpiredAt != nil && time.Now().Unix() >= poll.ExpiredAt.Unix() {
+	if services.IsPollEnded(poll) {
 		return fiber.NewError(fiber.StatusBadRequest, "poll has been ended")
 	}
 
-	doesContains := false
-	for _, option := range poll.Options {
-		if option.ID == data.Answer {
-			doesContains = true
-			break
-		}
-	}
-	if !doesContains {
-		return fiber.NewError(fiber.StatusBadRequest, "poll does not have a option like that")
+	if err := services.ValidatePollAnswer(poll, data.Answers); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
 	answer := models.PollAnswer{
-		Answer:    data.Answer,
+		Answers:   data.Answers,
 		PollID:    poll.ID,
 		AccountID: user.ID,
 	}
@@ -76,3 +149,61 @@ func answerPoll(c *fiber.Ctx) error {
 		return c.JSON(answer)
 	}
 }
+
+func retractPollAnswer(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	pollId, _ := c.ParamsInt("pollId")
+
+	var poll models.Poll
+	if err := database.C.Where("id = ?", pollId).First(&poll).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	if services.IsPollEnded(poll) {
+		return fiber.NewError(fiber.StatusBadRequest, "poll has been ended")
+	} else if !poll.AllowChangeAnswer {
+		return fiber.NewError(fiber.StatusForbidden, "this poll does not allow changing answer")
+	}
+
+	if err := services.RetractPollAnswer(poll, user.ID); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func reactPollOption(c *fiber.Ctx) error {
+	if err := sec.EnsureGrantedPerm(c, "CreateReactions", true); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	pollId, _ := c.ParamsInt("pollId")
+	optionId := c.Params("optionId")
+
+	// The poll may not belong to any post, the reaction will not notify anyone then
+	poll, op, err := getVisiblePoll(pollId, user)
+	if err != nil {
+		return err
+	}
+	if !lo.ContainsBy(poll.Options, func(item models.PollOption) bool {
+		return item.ID == optionId
+	}) {
+		return fiber.NewError(fiber.StatusBadRequest, "poll does not have a option like that")
+	}
+
+	reaction, err := bindReaction(c, user, op, models.ReactionTargetPollOption, poll.ID, optionId)
+	if err != nil {
+		return err
+	}
+
+	if positive, reaction, err := services.ReactPost(user, op, reaction); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	} else {
+		return c.Status(lo.Ternary(positive, fiber.StatusCreated, fiber.StatusNoContent)).JSON(reaction)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"reflect"
 	"time"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
@@ -20,7 +21,15 @@ func getPoll(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, err.Error())
 	}
 
+	var userID *uint
+	var hasAnswered bool
+	if user, authenticated := c.Locals("user").(authm.Account); authenticated {
+		userID = &user.ID
+		hasAnswered = services.HasAnsweredPoll(poll, user.ID)
+	}
+
 	poll.Metric = services.GetPollMetric(poll)
+	services.HidePollResult(&poll, userID, hasAnswered)
 
 	return c.JSON(poll)
 }
@@ -32,8 +41,14 @@ func createPoll(c *fiber.Ctx) error {
 	user := c.Locals("user").(authm.Account)
 
 	var data struct {
-		Options   []models.PollOption `json:"options" validate:"required"`
-		ExpiredAt *time.Time          `json:"expired_at"`
+		Options           []models.PollOption         `json:"options" validate:"required"`
+		ExpiredAt         *time.Time                  `json:"expired_at"`
+		Mode              models.PollMode             `json:"mode"`
+		MaxChoices        int                         `json:"max_choices"`
+		IsAnonymous       bool                        `json:"is_anonymous"`
+		IsVoterPublic     bool                        `json:"is_voter_public"`
+		ResultVisibility  models.PollResultVisibility `json:"result_visibility"`
+		AllowChangeAnswer bool                        `json:"allow_change_answer"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
@@ -41,9 +56,16 @@ func createPoll(c *fiber.Ctx) error {
 	}
 
 	poll := models.Poll{
-		ExpiredAt: data.ExpiredAt,
-		Options:   data.Options,
-		AccountID: user.ID,
+		ExpiredAt:        data.ExpiredAt,
+		Options:          data.Options,
+		AccountID:        user.ID,
+		Mode:             data.Mode,
+		MaxChoices:       data.MaxChoices,
+		IsAnonymous:      data.IsAnonymous,
+		IsVoterPublic:    data.IsVoterPublic,
+		ResultVisibility: data.ResultVisibility,
+
+		AllowChangeAnswer: data.AllowChangeAnswer,
 	}
 
 	var err error
@@ -63,8 +85,14 @@ func updatePoll(c *fiber.Ctx) error {
 	user := c.Locals("user").(authm.Account)
 
 	var data struct {
-		Options   []models.PollOption `json:"options" validate:"required"`
-		ExpiredAt *time.Time          `json:"expired_at"`
+		Options           []models.PollOption         `json:"options" validate:"required"`
+		ExpiredAt         *time.Time                  `json:"expired_at"`
+		Mode              models.PollMode             `json:"mode"`
+		MaxChoices        int                         `json:"max_choices"`
+		IsAnonymous       bool                        `json:"is_anonymous"`
+		IsVoterPublic     bool                        `json:"is_voter_public"`
+		ResultVisibility  models.PollResultVisibility `json:"result_visibility"`
+		AllowChangeAnswer bool                        `json:"allow_change_answer"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
@@ -76,8 +104,29 @@ func updatePoll(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, err.Error())
 	}
 
+	if poll.ClosedAt != nil {
+		return fiber.NewError(fiber.StatusForbidden, "poll has been closed")
+	}
+
+	// The options cannot be changed once someone answered, otherwise the existing answers will be orphaned
+	if services.CountPollAnswer(poll) > 0 {
+		if !reflect.DeepEqual([]models.PollOption(poll.Options), data.Options) ||
+			poll.Mode != data.Mode ||
+			poll.MaxChoices != data.MaxChoices ||
+			poll.IsAnonymous != data.IsAnonymous ||
+			(!poll.IsVoterPublic && data.IsVoterPublic) {
+			return fiber.NewError(fiber.StatusForbidden, "options cannot be changed after someone answered the poll")
+		}
+	}
+
 	poll.Options = data.Options
 	poll.ExpiredAt = data.ExpiredAt
+	poll.Mode = data.Mode
+	poll.MaxChoices = data.MaxChoices
+	poll.IsAnonymous = data.IsAnonymous
+	poll.IsVoterPublic = data.IsVoterPublic
+	poll.ResultVisibility = data.ResultVisibility
+	poll.AllowChangeAnswer = data.AllowChangeAnswer
 
 	var err error
 	if poll, err = services.UpdatePoll(poll); err != nil {
@@ -87,6 +136,27 @@ func updatePoll(c *fiber.Ctx) error {
 	return c.JSON(poll)
 }
 
+func closePoll(c *fiber.Ctx) error {
+	pollId, _ := c.ParamsInt("pollId")
+
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var poll models.Poll
+	if err := database.C.Where("id = ? AND account_id = ?", pollId, user.ID).First(&poll).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	var err error
+	if poll, err = services.ClosePoll(poll); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(poll)
+}
+
 func deletePoll(c *fiber.Ctx) error {
 	pollId, _ := c.ParamsInt("pollId")
 
@@ -8,7 +8,6 @@ import (
 	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 	"gorm.io/gorm"
 	"strconv"
-	"strings"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
@@ -51,15 +50,42 @@ func universalPostFilter(c *fiber.Ctx, tx *gorm.DB) (*gorm.DB, error) {
 		tx = services.FilterPostWithType(tx, c.Query("type"))
 	}
 
+	if len(c.Query("lang")) > 0 {
+		var err error
+		if tx, err = services.FilterPostWithLanguage(tx, c.Query("lang"), exts.GetAuthenticatedUser(c)); err != nil {
+			return tx, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+	}
+
 	return tx, nil
 }
 
+// applyPostLanguage will apply the language given by the author to the post.
+// The override is kept when the field is absent, and clearing it lets the language be detected again.
+func applyPostLanguage(item *models.Post, language *string) {
+	if language == nil {
+		return
+	}
+	item.Language = *language
+	item.IsLanguageOverridden = len(*language) > 0
+}
+
+// renderPostContent will render the content of the posts into html when the client asked with render=html.
+func renderPostContent(c *fiber.Ctx, items ...*models.Post) error {
+	if c.Query("render") != "html" {
+		return nil
+	}
+	for _, item := range items {
+		if err := services.RenderPostContent(item); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+	}
+	return nil
+}
+
 func getPost(c *fiber.Ctx) error {
 	id := c.Params("postId")
 
-	var item models.Post
-	var err error
-
 	tx := services.FilterPostDraft(database.C)
 
 	if user, authenticated := c.Locals("user").(authm.Account); authenticated {
@@ -68,18 +94,7 @@ func getPost(c *fiber.Ctx) error {
 		tx = services.FilterPostWithUserContext(tx, nil)
 	}
 
-	if numericId, paramErr := strconv.Atoi(id); paramErr == nil {
-		item, err = services.GetPost(tx, uint(numericId))
-	} else {
-		segments := strings.Split(id, ":")
-		if len(segments) != 2 {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid post id, must be a number or a string with two segment divided by a colon")
-		}
-		area := segments[0]
-		alias := segments[1]
-		item, err = services.GetPostByAlias(tx, alias, area)
-	}
-
+	item, err := services.GetPostByIdentifier(tx, id)
 	if err != nil {
 		return fiber.NewError(fiber.StatusNotFound, err.Error())
 	}
@@ -88,11 +103,38 @@ func getPost(c *fiber.Ctx) error {
 		ReplyCount:    services.CountPostReply(item.ID),
 		ReactionCount: services.CountPostReactions(item.ID),
 	}
-	item.Metric.ReactionList, err = services.ListPostReactions(database.C.Where("post_id = ?", item.ID))
+	item.Metric.ReactionList, err = services.ListReactions(database.C.Where("post_id = ?", item.ID))
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
+	if err := services.HydratePostPolls([]*models.Post{&item}, exts.GetAuthenticatedUser(c)); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user := exts.GetAuthenticatedUser(c); user != nil {
+		if mapping, err := services.BatchListUserReactions(user.ID, []uint{item.ID}); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		} else {
+			item.MyReactions = mapping[item.ID]
+		}
+	}
+
+	if item.SeriesID != nil {
+		navTx := services.FilterPostDraft(database.C)
+		navTx = services.FilterPostWithUserContext(navTx, exts.GetAuthenticatedUser(c))
+		item.SeriesNavigation, err = services.GetPostSeriesNavigation(navTx, item)
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	services.HydrateArticleBlocks(&item)
+
+	if err := renderPostContent(c, &item); err != nil {
+		return err
+	}
+
 	return c.JSON(item)
 }
 
@@ -120,7 +162,7 @@ func searchPost(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
-	items, err := services.ListPost(tx, take, offset, "published_at DESC")
+	items, err := services.ListPost(tx, take, offset, "published_at DESC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -133,6 +175,10 @@ func searchPost(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -156,7 +202,7 @@ func listPost(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
-	items, err := services.ListPost(tx, take, offset, "published_at DESC")
+	items, err := services.ListPost(tx, take, offset, "published_at DESC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -169,6 +215,10 @@ func listPost(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -205,6 +255,10 @@ func listPostMinimal(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -227,7 +281,7 @@ func listDraftPost(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
-	items, err := services.ListPost(tx, take, offset, "created_at DESC", true)
+	items, err := services.ListPost(tx, take, offset, "created_at DESC", &user, true)
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -240,6 +294,10 @@ func listDraftPost(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -285,35 +343,53 @@ func deletePost(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusOK)
 }
 
-func reactPost(c *fiber.Ctx) error {
-	if err := sec.EnsureGrantedPerm(c, "CreateReactions", true); err != nil {
-		return err
-	}
-	user := c.Locals("user").(authm.Account)
-
+// bindReaction will parse the reaction from the request body, the attitude is decided by the catalog instead of the client.
+func bindReaction(c *fiber.Ctx, user authm.Account, op models.Post, targetType models.ReactionTargetType, targetID uint, targetKey string) (models.Reaction, error) {
 	var data struct {
-		Symbol   string                  `json:"symbol"`
-		Attitude models.ReactionAttitude `json:"attitude"`
+		Symbol string `json:"symbol" validate:"required"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
-		return err
+		return models.Reaction{}, err
 	}
 
-	reaction := models.Reaction{
-		Symbol:    data.Symbol,
-		Attitude:  data.Attitude,
-		AccountID: user.ID,
+	emoji, err := services.ResolveReactionEmoji(data.Symbol, op, user)
+	if err != nil {
+		// The symbols not in the catalog were used before it existed, the user can still take them back
+		existing, existErr := services.GetUserReaction(targetType, targetID, targetKey, user.ID, data.Symbol)
+		if existErr != nil {
+			return models.Reaction{}, fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		emoji = models.ReactionEmoji{Symbol: existing.Symbol, Attitude: existing.Attitude}
 	}
 
+	return models.Reaction{
+		Symbol:     emoji.Symbol,
+		Attitude:   emoji.Attitude,
+		TargetType: targetType,
+		TargetID:   targetID,
+		TargetKey:  targetKey,
+		AccountID:  user.ID,
+	}, nil
+}
+
+func reactPost(c *fiber.Ctx) error {
+	if err := sec.EnsureGrantedPerm(c, "CreateReactions", true); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
 	var res models.Post
-	if err := database.C.Where("id = ?", c.Params("postId")).Select("id").First(&res).Error; err != nil {
+	if err := database.C.Where("id = ?", c.Params("postId")).Preload("Publisher").First(&res).Error; err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to find post to react: %v", err))
-	} else {
-		reaction.PostID = res.ID
 	}
 
-	if positive, reaction, err := services.ReactPost(user, reaction); err != nil {
+	reaction, err := bindReaction(c, user, res, models.ReactionTargetPost, res.ID, "")
+	if err != nil {
+		return err
+	}
+
+	if positive, reaction, err := services.ReactPost(user, res, reaction); err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	} else {
 		_ = authkit.AddEventExt(
@@ -327,6 +403,105 @@ func reactPost(c *fiber.Ctx) error {
 	}
 }
 
+func reactPostAnchor(c *fiber.Ctx) error {
+	if err := sec.EnsureGrantedPerm(c, "CreateReactions", true); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	anchor := c.Params("anchor")
+
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, &user)
+
+	var res models.Post
+	if err := tx.Where("id = ? AND type = ?", c.Params("postId"), models.PostTypeArticle).
+		Preload("Publisher").
+		First(&res).Error; err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to find article to react: %v", err))
+	}
+
+	// The anchors removed by editing are still accepted when the user reacted on them, so the reaction can be taken back
+	if !lo.Contains(services.ListArticleAnchors(res), anchor) {
+		var count int64
+		services.FilterReactionWithTarget(database.C, models.ReactionTargetPostAnchor, res.ID).
+			Model(&models.Reaction{}).
+			Where("target_key = ? AND account_id = ?", anchor, user.ID).
+			Count(&count)
+		if count == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "article does not have an anchor like that")
+		}
+	}
+
+	reaction, err := bindReaction(c, user, res, models.ReactionTargetPostAnchor, res.ID, anchor)
+	if err != nil {
+		return err
+	}
+
+	if positive, reaction, err := services.ReactPost(user, res, reaction); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	} else {
+		return c.Status(lo.Ternary(positive, fiber.StatusCreated, fiber.StatusNoContent)).JSON(reaction)
+	}
+}
+
+func listPostAnchorReactions(c *fiber.Ctx) error {
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, exts.GetAuthenticatedUser(c))
+
+	var item models.Post
+	if err := tx.Where("id = ?", c.Params("postId")).Select("id").First(&item).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	reactions, err := services.BatchListReactions[string](
+		services.FilterReactionWithTarget(database.C, models.ReactionTargetPostAnchor, item.ID),
+		"target_key",
+	)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(reactions)
+}
+
+func listPostReactions(c *fiber.Ctx) error {
+	take := c.QueryInt("take", 0)
+	offset := c.QueryInt("offset", 0)
+
+	user := exts.GetAuthenticatedUser(c)
+
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, user)
+
+	var item models.Post
+	if err := tx.Where("id = ?", c.Params("postId")).Select("id").First(&item).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	tx = database.C.Where("post_id = ?", item.ID)
+	if len(c.Query("symbol")) > 0 {
+		tx = tx.Where("symbol = ?", c.Query("symbol"))
+	}
+	tx = services.FilterReactorWithUserContext(tx, user)
+
+	countTx := tx
+	count, err := services.CountPostReactor(countTx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	items, err := services.ListPostReactor(tx, take, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(fiber.Map{
+		"count": count,
+		"data":  items,
+	})
+}
+
 func pinPost(c *fiber.Ctx) error {
 	if err := sec.EnsureAuthenticated(c); err != nil {
 		return err
@@ -28,7 +28,7 @@ func listPinnedPost(c *fiber.Ctx) error {
 	tx = tx.Where("publisher_id = ?", user.ID)
 	tx = tx.Where("pinned_at IS NOT NULL")
 
-	items, err := services.ListPost(tx, 100, 0, "published_at DESC")
+	items, err := services.ListPost(tx, 100, 0, "published_at DESC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -47,6 +47,64 @@ func getPublisher(c *fiber.Ctx) error {
 	return c.JSON(publisher)
 }
 
+// canSeePublisherFollowers will check the current user can see the followers of the publisher,
+// only the owner can see them when the publisher turned on HideFollowers.
+func canSeePublisherFollowers(c *fiber.Ctx, publisher models.Publisher) bool {
+	if !publisher.HideFollowers {
+		return true
+	}
+	user, authenticated := c.Locals("user").(authm.Account)
+	return authenticated && publisher.AccountID != nil && *publisher.AccountID == user.ID
+}
+
+func getPublisherStats(c *fiber.Ctx) error {
+	name := c.Params("name")
+	days := c.QueryInt("days", 30)
+	days = max(1, min(days, 365))
+
+	var publisher models.Publisher
+	if err := database.C.Where("name = ?", name).First(&publisher).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	stats, err := services.GetPublisherStats(publisher, days, canSeePublisherFollowers(c, publisher))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(stats)
+}
+
+func listPublisherFollowers(c *fiber.Ctx) error {
+	take := c.QueryInt("take", 0)
+	offset := c.QueryInt("offset", 0)
+	name := c.Params("name")
+
+	var publisher models.Publisher
+	if err := database.C.Where("name = ?", name).First(&publisher).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	if !canSeePublisherFollowers(c, publisher) {
+		return fiber.NewError(fiber.StatusForbidden, "this publisher's followers list is hidden")
+	}
+
+	followers, err := services.ListPublisherFollower(publisher, take, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	accounts, err := authkit.ListUser(gap.Nx, followers)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("unable to get followers info: %v", err))
+	}
+
+	return c.JSON(fiber.Map{
+		"count": publisher.TotalFollower,
+		"data":  accounts,
+	})
+}
+
 func listRelatedPublisher(c *fiber.Ctx) error {
 	tx := database.C
 	if len(c.Query("user")) > 0 {
@@ -174,12 +232,13 @@ func editPublisher(c *fiber.Ctx) error {
 	}
 
 	var data struct {
-		Name        string `json:"name"`
-		Nick        string `json:"nick"`
-		Description string `json:"description"`
-		Avatar      string `json:"avatar"`
-		Banner      string `json:"banner"`
-		AccountID   *uint  `json:"account_id"`
+		Name          string `json:"name"`
+		Nick          string `json:"nick"`
+		Description   string `json:"description"`
+		Avatar        string `json:"avatar"`
+		Banner        string `json:"banner"`
+		HideFollowers *bool  `json:"hide_followers"`
+		AccountID     *uint  `json:"account_id"`
 	}
 
 	if err := exts.BindAndValidate(c, &data); err != nil {
@@ -191,6 +250,9 @@ func editPublisher(c *fiber.Ctx) error {
 	publisher.Description = data.Description
 	publisher.Avatar = data.Avatar
 	publisher.Banner = data.Banner
+	if data.HideFollowers != nil {
+		publisher.HideFollowers = *data.HideFollowers
+	}
 	if data.AccountID != nil {
 		publisher.AccountID = data.AccountID
 	}
@@ -42,6 +42,7 @@ func createQuestion(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 		Reward         float64           `json:"reward"`
 	}
@@ -94,7 +95,6 @@ func createQuestion(c *fiber.Ctx) error {
 		Alias:          data.Alias,
 		Type:           models.PostTypeQuestion,
 		Body:           bodyMapping,
-		Language:       services.DetectLanguage(data.Content),
 		Tags:           data.Tags,
 		Categories:     data.Categories,
 		PublishedAt:    data.PublishedAt,
@@ -104,6 +104,7 @@ func createQuestion(c *fiber.Ctx) error {
 		InvisibleUsers: data.InvisibleUsers,
 		PublisherID:    publisher.ID,
 	}
+	applyPostLanguage(&item, data.Language)
 
 	if item.PublishedAt == nil {
 		item.PublishedAt = lo.ToPtr(time.Now())
@@ -170,6 +171,7 @@ func editQuestion(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 	}
 
@@ -229,7 +231,7 @@ func editQuestion(c *fiber.Ctx) error {
 
 	item.Alias = data.Alias
 	item.Body = newBodyMapping
-	item.Language = services.DetectLanguage(data.Content)
+	applyPostLanguage(&item, data.Language)
 	item.Tags = data.Tags
 	item.Categories = data.Categories
 	item.IsDraft = data.IsDraft
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"sort"
+
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
 	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
@@ -26,7 +29,7 @@ func listRecommendation(c *fiber.Ctx) error {
 	})
 
 	tx := database.C.Where("id IN ?", postIdx)
-	newPosts, err := services.ListPost(tx, featuredMax, 0, "id ASC")
+	newPosts, err := services.ListPost(tx, featuredMax, 0, "id ASC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
@@ -39,6 +42,13 @@ func listRecommendation(c *fiber.Ctx) error {
 		posts[idx] = newPostMap[item.ID]
 	}
 
+	// Boost the posts in the preferred languages, the order of the others is kept
+	if languages := services.GetPreferredLanguages(exts.GetAuthenticatedUser(c)); len(languages) > 0 {
+		sort.SliceStable(posts, func(i, j int) bool {
+			return lo.Contains(languages, posts[i].Language) && !lo.Contains(languages, posts[j].Language)
+		})
+	}
+
 	return c.JSON(posts)
 }
 
@@ -76,7 +86,7 @@ func listRecommendationFriends(c *fiber.Ctx) error {
 		order = "published_at DESC, (COALESCE(total_upvote, 0) - COALESCE(total_downvote, 0)) DESC"
 	}
 
-	items, err := services.ListPost(tx, take, offset, order)
+	items, err := services.ListPost(tx, take, offset, order, &user)
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -89,6 +99,10 @@ func listRecommendationFriends(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -112,7 +126,10 @@ func listRecommendationShuffle(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
-	items, err := services.ListPost(tx, take, offset, "RANDOM()")
+	user := exts.GetAuthenticatedUser(c)
+	order := services.ShufflePostWithLanguages(services.GetPreferredLanguages(user))
+
+	items, err := services.ListPost(tx, take, offset, order, user)
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
@@ -125,6 +142,10 @@ func listRecommendationShuffle(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
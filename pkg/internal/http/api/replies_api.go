@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
 	"github.com/gofiber/fiber/v2"
@@ -40,11 +41,15 @@ func listPostReplies(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
 	}
 
-	items, err := services.ListPost(tx, take, offset, "published_at DESC")
+	items, err := services.ListPost(tx, take, offset, "published_at DESC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
@@ -78,7 +83,7 @@ func listPostFeaturedReply(c *fiber.Ctx) error {
 		tx = services.FilterPostWithTag(tx, c.Query("tag"))
 	}
 
-	items, err := services.ListPost(tx, take, 0, "(COALESCE(total_upvote, 0) - COALESCE(total_downvote, 0)) DESC, published_at DESC")
+	items, err := services.ListPost(tx, take, 0, "(COALESCE(total_upvote, 0) - COALESCE(total_downvote, 0)) DESC, published_at DESC", exts.GetAuthenticatedUser(c))
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
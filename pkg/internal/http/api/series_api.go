@@ -0,0 +1,182 @@
+package api
+
+import (
+	"fmt"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func getOwnedSeries(c *fiber.Ctx, user authm.Account) (models.Series, error) {
+	id, _ := c.ParamsInt("seriesId", 0)
+	series, err := services.GetSeries(uint(id))
+	if err != nil {
+		return series, fiber.NewError(fiber.StatusNotFound, err.Error())
+	} else if series.Publisher.AccountID == nil || *series.Publisher.AccountID != user.ID {
+		return series, fiber.NewError(fiber.StatusForbidden, "you are not the owner of this series")
+	}
+	return series, nil
+}
+
+func listSeries(c *fiber.Ctx) error {
+	name := c.Query("publisher")
+	if len(name) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "publisher is required")
+	}
+
+	var publisher models.Publisher
+	if err := database.C.Where("name = ?", name).First(&publisher).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	series, err := services.ListSeries(publisher)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(series)
+}
+
+func getSeries(c *fiber.Ctx) error {
+	id, _ := c.ParamsInt("seriesId", 0)
+	series, err := services.GetSeries(uint(id))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, exts.GetAuthenticatedUser(c))
+	tx = tx.Where("series_id = ?", series.ID)
+
+	items, err := services.ListPost(tx, 100, 0, "series_order ASC", exts.GetAuthenticatedUser(c))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	for _, item := range items {
+		if item != nil {
+			*item = services.TruncatePostContent(*item)
+		}
+	}
+
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"series": series,
+		"posts":  items,
+	})
+}
+
+func createSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var data struct {
+		Publisher   uint   `json:"publisher"`
+		Title       string `json:"title" validate:"required,max=1024"`
+		Description string `json:"description" validate:"max=4096"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	publisher, err := services.GetPublisher(data.Publisher, user.ID)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	series, err := services.NewSeries(models.Series{
+		Title:       data.Title,
+		Description: data.Description,
+		PublisherID: publisher.ID,
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(series)
+}
+
+func editSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	series, err := getOwnedSeries(c, user)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Title       string `json:"title" validate:"required,max=1024"`
+		Description string `json:"description" validate:"max=4096"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	series.Title = data.Title
+	series.Description = data.Description
+
+	if series, err = services.EditSeries(series); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(series)
+}
+
+func reorderSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	series, err := getOwnedSeries(c, user)
+	if err != nil {
+		return err
+	}
+
+	var data struct {
+		Posts []uint `json:"posts"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	if err := services.ReorderSeries(series, data.Posts); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to reorder series: %v", err))
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func deleteSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	series, err := getOwnedSeries(c, user)
+	if err != nil {
+		return err
+	}
+
+	if err := services.DeleteSeries(series); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -41,6 +41,7 @@ func createStory(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 		ReplyTo        *uint             `json:"reply_to"`
 		RepostTo       *uint             `json:"repost_to"`
@@ -74,7 +75,6 @@ func createStory(c *fiber.Ctx) error {
 		Alias:          data.Alias,
 		Type:           models.PostTypeStory,
 		Body:           bodyMapping,
-		Language:       services.DetectLanguage(data.Content),
 		Tags:           data.Tags,
 		Categories:     data.Categories,
 		PublishedAt:    data.PublishedAt,
@@ -85,6 +85,7 @@ func createStory(c *fiber.Ctx) error {
 		PublisherID:    publisher.ID,
 		PollID:         data.Poll,
 	}
+	applyPostLanguage(&item, data.Language)
 
 	if item.PublishedAt == nil {
 		item.PublishedAt = lo.ToPtr(time.Now())
@@ -150,6 +151,7 @@ func editStory(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 		Poll           *uint             `json:"poll"`
 	}
@@ -202,7 +204,7 @@ func editStory(c *fiber.Ctx) error {
 
 	item.Alias = data.Alias
 	item.Body = bodyMapping
-	item.Language = services.DetectLanguage(data.Content)
+	applyPostLanguage(&item, data.Language)
 	item.Tags = data.Tags
 	item.Categories = data.Categories
 	item.IsDraft = data.IsDraft
@@ -7,11 +7,77 @@ import (
 	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 	"strconv"
 
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
 	"github.com/gofiber/fiber/v2"
 )
 
+func listSubscriptions(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	take := c.QueryInt("take", 0)
+	offset := c.QueryInt("offset", 0)
+
+	tx := database.C.Where("follower_id = ?", user.ID)
+
+	var err error
+	if tx, err = services.FilterSubscriptionWithKind(tx, c.Query("kind")); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	countTx := tx
+	count, err := services.CountSubscription(countTx)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	items, err := services.ListSubscription(tx, take, offset)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(fiber.Map{
+		"count": count,
+		"data":  items,
+	})
+}
+
+func editSubscription(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var data struct {
+		Mode           models.SubscriptionMode           `json:"mode" validate:"min=0,max=2"`
+		DigestInterval models.SubscriptionDigestInterval `json:"digest_interval" validate:"min=0,max=1"`
+		Priority       int                               `json:"priority" validate:"required,min=1,max=5"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	id, _ := c.ParamsInt("subscriptionId", 0)
+	subscription, err := services.GetSubscription(user, uint(id))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	subscription, err = services.EditSubscription(subscription, data.Mode, data.DigestInterval, data.Priority)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	return c.JSON(subscription)
+}
+
 func getSubscriptionOnUser(c *fiber.Ctx) error {
 	if err := sec.EnsureAuthenticated(c); err != nil {
 		return err
@@ -78,6 +144,28 @@ func getSubscriptionOnCategory(c *fiber.Ctx) error {
 	return c.JSON(subscription)
 }
 
+func getSubscriptionOnSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	seriesId, err := c.ParamsInt("seriesId", 0)
+	series, err := services.GetSeries(uint(seriesId))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("unable to get series: %v", err))
+	}
+
+	subscription, err := services.GetSubscriptionOnSeries(user, series)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to get subscription: %v", err))
+	} else if subscription == nil {
+		return fiber.NewError(fiber.StatusNotFound, "subscription does not exist")
+	}
+
+	return c.JSON(subscription)
+}
+
 func subscribeToUser(c *fiber.Ctx) error {
 	if err := sec.EnsureAuthenticated(c); err != nil {
 		return err
@@ -159,6 +247,33 @@ func subscribeToCategory(c *fiber.Ctx) error {
 	return c.JSON(subscription)
 }
 
+func subscribeToSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	seriesId, err := c.ParamsInt("seriesId", 0)
+	series, err := services.GetSeries(uint(seriesId))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("unable to get series: %v", err))
+	}
+
+	subscription, err := services.SubscribeToSeries(user, series)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to subscribe to series: %v", err))
+	}
+
+	_ = authkit.AddEventExt(
+		gap.Nx,
+		"posts.subscribe.series",
+		strconv.Itoa(int(series.ID)),
+		c,
+	)
+
+	return c.JSON(subscription)
+}
+
 func unsubscribeFromUser(c *fiber.Ctx) error {
 	if err := sec.EnsureAuthenticated(c); err != nil {
 		return err
@@ -239,3 +354,30 @@ func unsubscribeFromCategory(c *fiber.Ctx) error {
 
 	return c.SendStatus(fiber.StatusOK)
 }
+
+func unsubscribeFromSeries(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	seriesId, err := c.ParamsInt("seriesId", 0)
+	series, err := services.GetSeries(uint(seriesId))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("unable to get series: %v", err))
+	}
+
+	err = services.UnsubscribeFromSeries(user, series)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("unable to unsubscribe from series: %v", err))
+	}
+
+	_ = authkit.AddEventExt(
+		gap.Nx,
+		"posts.unsubscribe.series",
+		strconv.Itoa(int(series.ID)),
+		c,
+	)
+
+	return c.SendStatus(fiber.StatusOK)
+}
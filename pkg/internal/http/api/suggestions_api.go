@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/http/exts"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func suggestPostMetadata(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	var data struct {
+		Title       string `json:"title" validate:"max=1024"`
+		Description string `json:"description" validate:"max=4096"`
+		Content     string `json:"content" validate:"required_without_all=Title Description"`
+	}
+
+	if err := exts.BindAndValidate(c, &data); err != nil {
+		return err
+	}
+
+	suggestion, err := services.SuggestPostMetadata(data.Title, data.Description, data.Content, user.ID)
+	if errors.Is(err, services.ErrSuggestionEmptyDraft) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(suggestion)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"errors"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/services"
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/sec"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func getPostTranslation(c *fiber.Ctx) error {
+	if err := sec.EnsureAuthenticated(c); err != nil {
+		return err
+	}
+	user := c.Locals("user").(authm.Account)
+
+	to := c.Query("to")
+	if len(to) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "target language is required")
+	} else if _, err := services.NormalizeTranslationLanguage(to); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	tx := services.FilterPostDraft(database.C)
+	tx = services.FilterPostWithUserContext(tx, &user)
+
+	item, err := services.GetPostByIdentifier(tx, c.Params("postId"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusNotFound, err.Error())
+	}
+
+	translation, err := services.TranslatePost(item, to, user.ID)
+	if errors.Is(err, services.ErrInsightRateLimited) {
+		return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	} else if errors.Is(err, services.ErrTranslationUnavailable) {
+		return fiber.NewError(fiber.StatusBadGateway, err.Error())
+	} else if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(translation)
+}
@@ -40,6 +40,7 @@ func createVideo(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 	}
 
@@ -69,7 +70,6 @@ func createVideo(c *fiber.Ctx) error {
 		Alias:          data.Alias,
 		Type:           models.PostTypeVideo,
 		Body:           bodyMapping,
-		Language:       services.DetectLanguage(data.Title),
 		Tags:           data.Tags,
 		Categories:     data.Categories,
 		PublishedAt:    data.PublishedAt,
@@ -79,6 +79,7 @@ func createVideo(c *fiber.Ctx) error {
 		InvisibleUsers: data.InvisibleUsers,
 		PublisherID:    publisher.ID,
 	}
+	applyPostLanguage(&item, data.Language)
 
 	if item.PublishedAt == nil {
 		item.PublishedAt = lo.ToPtr(time.Now())
@@ -128,6 +129,7 @@ func editVideo(c *fiber.Ctx) error {
 		VisibleUsers   []uint            `json:"visible_users_list"`
 		InvisibleUsers []uint            `json:"invisible_users_list"`
 		Visibility     *int8             `json:"visibility"`
+		Language       *string           `json:"language" validate:"omitempty,len=2"`
 		IsDraft        bool              `json:"is_draft"`
 	}
 
@@ -178,7 +180,7 @@ func editVideo(c *fiber.Ctx) error {
 
 	item.Alias = data.Alias
 	item.Body = bodyMapping
-	item.Language = services.DetectLanguage(data.Title)
+	applyPostLanguage(&item, data.Language)
 	item.Tags = data.Tags
 	item.Categories = data.Categories
 	item.IsDraft = data.IsDraft
@@ -35,11 +35,15 @@ func getWhatsNew(c *fiber.Ctx) error {
 		order = "published_at DESC, (COALESCE(total_upvote, 0) - COALESCE(total_downvote, 0)) DESC"
 	}
 
-	items, err := services.ListPost(tx, 10, 0, order)
+	items, err := services.ListPost(tx, 10, 0, order, &user)
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadRequest, err.Error())
 	}
 
+	if err := renderPostContent(c, items...); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"count": count,
 		"data":  items,
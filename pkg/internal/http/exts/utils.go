@@ -1,6 +1,7 @@
 package exts
 
 import (
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
@@ -16,3 +17,11 @@ func BindAndValidate(c *fiber.Ctx, out any) error {
 
 	return nil
 }
+
+// GetAuthenticatedUser will return the current user, or nil when the request is not authenticated.
+func GetAuthenticatedUser(c *fiber.Ctx) *authm.Account {
+	if user, authenticated := c.Locals("user").(authm.Account); authenticated {
+		return &user
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+)
+
+type AnnotationVisibility = int8
+
+const (
+	AnnotationVisibilityPrivate = AnnotationVisibility(iota)
+	AnnotationVisibilityAuthor
+	AnnotationVisibilityPublic
+)
+
+// Annotation is a highlight or a margin note on a passage of an article.
+// The passage is anchored by the range in runes, and the quote with its surroundings,
+// which is used to find the passage again when the content was edited.
+type Annotation struct {
+	cruda.BaseModel
+
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Quote       string `json:"quote"`
+	Prefix      string `json:"prefix"`
+	Suffix      string `json:"suffix"`
+	IsOrphaned  bool   `json:"is_orphaned"`
+
+	Content    string               `json:"content"`
+	Visibility AnnotationVisibility `json:"visibility"`
+	ResolvedAt *time.Time           `json:"resolved_at"`
+
+	PostID    uint `json:"post_id" gorm:"index"`
+	AccountID uint `json:"account_id"`
+}
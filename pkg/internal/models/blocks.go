@@ -0,0 +1,27 @@
+package models
+
+type ArticleBlockType = string
+
+const (
+	ArticleBlockParagraph = ArticleBlockType("paragraph")
+	ArticleBlockHeading   = ArticleBlockType("heading")
+	ArticleBlockCode      = ArticleBlockType("code")
+	ArticleBlockQuote     = ArticleBlockType("quote")
+	ArticleBlockImage     = ArticleBlockType("image")
+	ArticleBlockEmbed     = ArticleBlockType("embed")
+	ArticleBlockCallout   = ArticleBlockType("callout")
+)
+
+// ArticleBlock is one block of the structured article document.
+// The Text of the paragraph, heading, quote and callout is inline markdown,
+// the Text of the code is the raw code.
+type ArticleBlock struct {
+	Type       ArticleBlockType `json:"type"`
+	Text       string           `json:"text,omitempty"`
+	Level      int              `json:"level,omitempty"`
+	Language   string           `json:"language,omitempty"`
+	Attachment string           `json:"attachment,omitempty"`
+	Caption    string           `json:"caption,omitempty"`
+	URL        string           `json:"url,omitempty"`
+	Variant    string           `json:"variant,omitempty"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+)
+
+// LinkPreview is the unfurled metadata of a link, shared by all the posts containing the link.
+type LinkPreview struct {
+	cruda.BaseModel
+
+	URL         string    `json:"url" gorm:"uniqueIndex;size:2048"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	Icon        string    `json:"icon"`
+	SiteName    string    `json:"site_name"`
+	Author      string    `json:"author"`
+	FetchedAt   time.Time `json:"fetched_at"`
+
+	// The failed links were cached for a short while, the FetchedAt is zero if the link never succeeded
+	Error    string     `json:"-"`
+	FailedAt *time.Time `json:"-"`
+}
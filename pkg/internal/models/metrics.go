@@ -5,3 +5,19 @@ type PostMetric struct {
 	ReactionCount int64            `json:"reaction_count"`
 	ReactionList  map[string]int64 `json:"reaction_list,omitempty"`
 }
+
+type PublisherStats struct {
+	PostCount       int64              `json:"post_count"`
+	PostCountByType map[string]int64   `json:"post_count_by_type"`
+	FollowerCount   *int64             `json:"follower_count"`
+	ReplyCount      int64              `json:"reply_count"`
+	ReactionCount   int64              `json:"reaction_count"`
+	ReactionList    map[string]int64   `json:"reaction_list"`
+	PostSeries      []StatsSeriesPoint `json:"post_series"`
+	FollowerSeries  []StatsSeriesPoint `json:"follower_series"`
+}
+
+type StatsSeriesPoint struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
+	"gorm.io/datatypes"
+)
+
+const (
+	OutboxKindNotification     = "notification"
+	OutboxKindPostSubscription = "post.subscription"
+)
+
+// NotificationOutbox is the durable queue of notifications.
+// The entries are written in the same transaction as the related resources and drained by a worker.
+type NotificationOutbox struct {
+	cruda.BaseModel
+
+	Kind         string                                   `json:"kind"`
+	UserIDs      datatypes.JSONSlice[uint64]              `json:"user_ids"`
+	Notification datatypes.JSONType[pushkit.Notification] `json:"notification"`
+	PostID       *uint                                    `json:"post_id"`
+
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"index"`
+	LastError     string     `json:"last_error"`
+	SentAt        *time.Time `json:"sent_at"`
+	DeadAt        *time.Time `json:"dead_at"`
+}
@@ -7,20 +7,56 @@ import (
 	"gorm.io/datatypes"
 )
 
+type PollMode = int8
+
+const (
+	PollModeSingle = PollMode(iota)
+	PollModeMultiple
+	PollModeRanked
+)
+
+type PollResultVisibility = int8
+
+const (
+	PollResultVisible = PollResultVisibility(iota)
+	PollResultAfterAnswered
+	PollResultAfterEnded
+)
+
 type Poll struct {
 	cruda.BaseModel
 
-	ExpiredAt *time.Time                      `json:"expired_at"`
-	Options   datatypes.JSONSlice[PollOption] `json:"options"`
-	AccountID uint                            `json:"account_id"`
+	ExpiredAt  *time.Time                      `json:"expired_at"`
+	ClosedAt   *time.Time                      `json:"closed_at"`
+	NotifiedAt *time.Time                      `json:"notified_at"`
+	Options    datatypes.JSONSlice[PollOption] `json:"options"`
+	AccountID  uint                            `json:"account_id"`
 
-	Metric PollMetric `json:"metric" gorm:"-"`
+	Mode             PollMode             `json:"mode"`
+	MaxChoices       int                  `json:"max_choices"`
+	IsAnonymous      bool                 `json:"is_anonymous"`
+	ResultVisibility PollResultVisibility `json:"result_visibility"`
+
+	AllowChangeAnswer bool `json:"allow_change_answer"`
+
+	// IsVoterPublic allows everyone who can see the poll to list the voters, otherwise only the owner can
+	IsVoterPublic bool `json:"is_voter_public" gorm:"default:false"`
+
+	// TotalAnswer is maintained along with the PollOptionTally, use the Metric instead
+	TotalAnswer int64 `json:"-"`
+
+	Metric     PollMetric  `json:"metric" gorm:"-"`
+	UserAnswer *PollAnswer `json:"user_answer,omitempty" gorm:"-"`
 }
 
 type PollMetric struct {
-	TotalAnswer         int64              `json:"total_answer"`
-	ByOptions           map[string]int64   `json:"by_options"`
-	ByOptionsPercentage map[string]float64 `json:"by_options_percentage"`
+	TotalAnswer         int64                       `json:"total_answer"`
+	ByOptions           map[string]int64            `json:"by_options"`
+	ByOptionsPercentage map[string]float64          `json:"by_options_percentage"`
+	Rounds              []map[string]int64          `json:"rounds,omitempty"`
+	Winner              *string                     `json:"winner,omitempty"`
+	ReactionList        map[string]map[string]int64 `json:"reaction_list,omitempty"`
+	IsHidden            bool                        `json:"is_hidden"`
 }
 
 type PollOption struct {
@@ -33,7 +69,17 @@ type PollOption struct {
 type PollAnswer struct {
 	cruda.BaseModel
 
-	Answer    string `json:"answer"`
-	PollID    uint   `json:"poll_id"`
-	AccountID uint   `json:"account_id"`
+	Answer    string                      `json:"answer"`
+	Answers   datatypes.JSONSlice[string] `json:"answers"`
+	PollID    uint                        `json:"poll_id" gorm:"uniqueIndex:idx_poll_answer_account,where:deleted_at IS NULL"`
+	AccountID uint                        `json:"account_id" gorm:"uniqueIndex:idx_poll_answer_account,where:deleted_at IS NULL"`
+}
+
+// PollOptionTally is the materialized count of answers of a poll option.
+// It is maintained in the same transaction as the answers, and recalculated periodically by RecalculatePollTally.
+type PollOptionTally struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	PollID   uint   `json:"poll_id" gorm:"uniqueIndex:idx_poll_option_tally"`
+	OptionID string `json:"option_id" gorm:"uniqueIndex:idx_poll_option_tally"`
+	Count    int64  `json:"count"`
 }
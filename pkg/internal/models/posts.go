@@ -29,19 +29,23 @@ const (
 type Post struct {
 	cruda.BaseModel
 
-	Type        string            `json:"type"`
-	Body        datatypes.JSONMap `json:"body" gorm:"index:,type:gin"`
-	Language    string            `json:"language"`
-	Alias       *string           `json:"alias"`
-	AliasPrefix *string           `json:"alias_prefix"`
-	Tags        []Tag             `json:"tags" gorm:"many2many:post_tags"`
-	Categories  []Category        `json:"categories" gorm:"many2many:post_categories"`
-	Reactions   []Reaction        `json:"reactions"`
-	Replies     []Post            `json:"replies" gorm:"foreignKey:ReplyID"`
-	ReplyID     *uint             `json:"reply_id"`
-	RepostID    *uint             `json:"repost_id"`
-	ReplyTo     *Post             `json:"reply_to" gorm:"foreignKey:ReplyID"`
-	RepostTo    *Post             `json:"repost_to" gorm:"foreignKey:RepostID"`
+	Type     string            `json:"type"`
+	Body     datatypes.JSONMap `json:"body" gorm:"index:,type:gin"`
+	Language string            `json:"language" gorm:"index"`
+	// LanguageScores is the top detected languages with their confidence, the Language may be overridden by the author
+	LanguageScores datatypes.JSONSlice[LanguageScore] `json:"language_scores"`
+	// IsLanguageOverridden is true when the Language was set by the author, otherwise it is detected again on every edit
+	IsLanguageOverridden bool       `json:"is_language_overridden"`
+	Alias                *string    `json:"alias"`
+	AliasPrefix          *string    `json:"alias_prefix"`
+	Tags                 []Tag      `json:"tags" gorm:"many2many:post_tags"`
+	Categories           []Category `json:"categories" gorm:"many2many:post_categories"`
+	Reactions            []Reaction `json:"reactions"`
+	Replies              []Post     `json:"replies" gorm:"foreignKey:ReplyID"`
+	ReplyID              *uint      `json:"reply_id"`
+	RepostID             *uint      `json:"repost_id"`
+	ReplyTo              *Post      `json:"reply_to" gorm:"foreignKey:ReplyID"`
+	RepostTo             *Post      `json:"repost_to" gorm:"foreignKey:RepostID"`
 
 	VisibleUsers   datatypes.JSONSlice[uint] `json:"visible_users_list"`
 	InvisibleUsers datatypes.JSONSlice[uint] `json:"invisible_users_list"`
@@ -61,13 +65,26 @@ type Post struct {
 	PollID *uint `json:"poll_id"`
 	Poll   *Poll `json:"poll"`
 
+	SeriesID         *uint                 `json:"series_id"`
+	Series           *Series               `json:"series,omitempty"`
+	SeriesOrder      int                   `json:"series_order"`
+	SeriesNavigation *PostSeriesNavigation `json:"series_navigation,omitempty" gorm:"-"`
+
 	RealmID *uint        `json:"realm_id"`
 	Realm   *authm.Realm `json:"realm" gorm:"-"`
 
 	PublisherID uint      `json:"publisher_id"`
 	Publisher   Publisher `json:"publisher"`
 
-	Metric PostMetric `json:"metric" gorm:"-"`
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty" gorm:"many2many:post_link_previews"`
+
+	Metric      PostMetric `json:"metric" gorm:"-"`
+	MyReactions []string   `json:"my_reactions,omitempty" gorm:"-"`
+}
+
+type LanguageScore struct {
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence"`
 }
 
 type PostStoryBody struct {
@@ -79,11 +96,23 @@ type PostStoryBody struct {
 }
 
 type PostArticleBody struct {
-	Thumbnail   *string  `json:"thumbnail"`
-	Title       string   `json:"title"`
-	Description *string  `json:"description"`
-	Content     string   `json:"content"`
-	Attachments []string `json:"attachments"`
+	Thumbnail   *string        `json:"thumbnail"`
+	Title       string         `json:"title"`
+	Description *string        `json:"description"`
+	Content     string         `json:"content"`
+	Blocks      []ArticleBlock `json:"blocks,omitempty"`
+	Attachments []string       `json:"attachments"`
+
+	// The metadata below was computed by the server when the article was saved
+	TableOfContent []PostTableOfContentEntry `json:"toc,omitempty"`
+	WordCount      int                       `json:"word_count"`
+	ReadingTime    int                       `json:"reading_time"`
+}
+
+type PostTableOfContentEntry struct {
+	Level  int    `json:"level"`
+	Title  string `json:"title"`
+	Anchor string `json:"anchor"`
 }
 
 type PostQuestionBody struct {
@@ -103,10 +132,32 @@ type PostVideoBody struct {
 	Subtitles   map[string]string `json:"subtitles"`
 }
 
+const (
+	PostInsightPending = "pending"
+	PostInsightDone    = "done"
+	PostInsightFailed  = "failed"
+)
+
+// PostInsight is the insight generated by the ai service, it is produced by a background job.
+// The ContentHash is the hash of the post body when the job was created, the insight is outdated once the hash changed.
+// Only one job can be pending for the same post body, the concurrent requests share it.
 type PostInsight struct {
 	cruda.BaseModel
 
-	Response string `json:"response"`
-	Post     Post   `json:"post"`
-	PostID   uint   `json:"post_id"`
+	Status      string `json:"status"`
+	Response    string `json:"response"`
+	Error       string `json:"error,omitempty"`
+	ContentHash string `json:"content_hash" gorm:"index;size:64;uniqueIndex:idx_post_insight_pending,priority:2"`
+	AccountID   uint   `json:"account_id" gorm:"index"`
+	Post        Post   `json:"post"`
+	PostID      uint   `json:"post_id" gorm:"uniqueIndex:idx_post_insight_pending,priority:1,where:status = 'pending' AND deleted_at IS NULL"`
+}
+
+// InsightUsage records a request to the ai service which does not produce a PostInsight, such as the translations.
+// It is counted along with the post insights by the insight rate limit.
+type InsightUsage struct {
+	cruda.BaseModel
+
+	Kind      string `json:"kind"`
+	AccountID uint   `json:"account_id" gorm:"index"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+	"gorm.io/datatypes"
+)
+
+type AccountPreference struct {
+	cruda.BaseModel
+
+	// Languages is the languages the user can read, the posts in them were preferred in the recommendations
+	Languages datatypes.JSONSlice[string] `json:"languages"`
+	AccountID uint                        `json:"account_id" gorm:"uniqueIndex"`
+}
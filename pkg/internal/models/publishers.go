@@ -23,6 +23,9 @@ type Publisher struct {
 
 	TotalUpvote   int `json:"total_upvote"`
 	TotalDownvote int `json:"total_downvote"`
+	TotalFollower int `json:"total_follower"`
+
+	HideFollowers bool `json:"hide_followers"`
 
 	RealmID   *uint `json:"realm_id"`
 	AccountID *uint `json:"account_id"`
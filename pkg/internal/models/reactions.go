@@ -2,6 +2,9 @@ package models
 
 import (
 	"time"
+
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 )
 
 type ReactionAttitude = uint8
@@ -12,14 +15,52 @@ const (
 	AttitudeNegative
 )
 
+type ReactionTargetType = string
+
+const (
+	ReactionTargetPost       = ReactionTargetType("post")
+	ReactionTargetPostAnchor = ReactionTargetType("post_anchor")
+	ReactionTargetPollOption = ReactionTargetType("poll_option")
+)
+
+// Reaction can target a post, an anchor inside an article, or an option of a poll.
+// The TargetKey is the anchor or the option id, and is empty when reacting to a post.
+// The PostID is only set for the reactions to posts, it is kept for the post vote counters.
 type Reaction struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
-	Symbol   string           `json:"symbol"`
+	Symbol   string           `json:"symbol" gorm:"uniqueIndex:idx_reaction_post_account_symbol;uniqueIndex:idx_reaction_target,priority:5"`
 	Attitude ReactionAttitude `json:"attitude"`
 
-	PostID    uint `json:"post_id"`
-	AccountID uint `json:"account_id"`
+	TargetType ReactionTargetType `json:"target_type" gorm:"uniqueIndex:idx_reaction_target,priority:1"`
+	TargetID   uint               `json:"target_id" gorm:"uniqueIndex:idx_reaction_target,priority:2"`
+	TargetKey  string             `json:"target_key" gorm:"uniqueIndex:idx_reaction_target,priority:3"`
+
+	PostID    *uint `json:"post_id" gorm:"uniqueIndex:idx_reaction_post_account_symbol"`
+	AccountID uint  `json:"account_id" gorm:"uniqueIndex:idx_reaction_post_account_symbol;uniqueIndex:idx_reaction_target,priority:4"`
+
+	Account *authm.Account `json:"account,omitempty" gorm:"-"`
+}
+
+// ReactionEmoji is an entry of the reaction catalog, both built-in and custom emoji.
+type ReactionEmoji struct {
+	Symbol     string           `json:"symbol"`
+	Icon       string           `json:"icon,omitempty"`
+	Attachment string           `json:"attachment,omitempty"`
+	Attitude   ReactionAttitude `json:"attitude"`
+}
+
+// RealmEmoji is the custom reaction emoji of a realm, the image is stored in Paperclip.
+// The symbol of it is `<realm id>:<alias>`.
+type RealmEmoji struct {
+	cruda.BaseModel
+
+	Alias      string           `json:"alias" gorm:"uniqueIndex:idx_realm_emoji_alias,where:deleted_at IS NULL"`
+	Name       string           `json:"name"`
+	Attachment string           `json:"attachment"`
+	Attitude   ReactionAttitude `json:"attitude"`
+	RealmID    uint             `json:"realm_id" gorm:"uniqueIndex:idx_realm_emoji_alias,where:deleted_at IS NULL"`
+	AccountID  uint             `json:"account_id"`
 }
@@ -0,0 +1,26 @@
+package models
+
+import "git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+
+// Series is an ordered collection of articles from the same publisher.
+type Series struct {
+	cruda.BaseModel
+
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	PublisherID uint      `json:"publisher_id"`
+	Publisher   Publisher `json:"publisher"`
+}
+
+type PostSeriesEntry struct {
+	ID          uint    `json:"id"`
+	Alias       *string `json:"alias"`
+	AliasPrefix *string `json:"alias_prefix"`
+	Title       string  `json:"title"`
+	SeriesOrder int     `json:"series_order"`
+}
+
+type PostSeriesNavigation struct {
+	Prev *PostSeriesEntry `json:"prev"`
+	Next *PostSeriesEntry `json:"next"`
+}
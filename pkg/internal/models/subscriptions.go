@@ -1,16 +1,43 @@
 package models
 
-import "git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+import (
+	"time"
+
+	"git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+)
+
+type SubscriptionMode = int8
+
+const (
+	SubscriptionModeInstant = SubscriptionMode(iota)
+	SubscriptionModeDigest
+	SubscriptionModeSilent
+)
+
+type SubscriptionDigestInterval = int8
+
+const (
+	SubscriptionDigestDaily = SubscriptionDigestInterval(iota)
+	SubscriptionDigestWeekly
+)
 
 type Subscription struct {
 	cruda.BaseModel
 
-	FollowerID uint       `json:"follower_id"`
+	// Each follower can only subscribe to the same target once, the indexes are partial so the unsubscribed ones are ignored
+	FollowerID uint       `json:"follower_id" gorm:"uniqueIndex:idx_subscription_account,where:deleted_at IS NULL;uniqueIndex:idx_subscription_tag,where:deleted_at IS NULL;uniqueIndex:idx_subscription_category,where:deleted_at IS NULL;uniqueIndex:idx_subscription_series,where:deleted_at IS NULL"`
 	Follower   Publisher  `json:"follower"`
-	AccountID  *uint      `json:"account_id,omitempty"`
+	AccountID  *uint      `json:"account_id,omitempty" gorm:"uniqueIndex:idx_subscription_account"`
 	Account    *Publisher `json:"account,omitempty"`
-	TagID      *uint      `json:"tag_id,omitempty"`
+	TagID      *uint      `json:"tag_id,omitempty" gorm:"uniqueIndex:idx_subscription_tag"`
 	Tag        Tag        `json:"tag,omitempty"`
-	CategoryID *uint      `json:"category_id,omitempty"`
+	CategoryID *uint      `json:"category_id,omitempty" gorm:"uniqueIndex:idx_subscription_category"`
 	Category   Category   `json:"category,omitempty"`
+	SeriesID   *uint      `json:"series_id,omitempty" gorm:"uniqueIndex:idx_subscription_series"`
+	Series     *Series    `json:"series,omitempty"`
+
+	Mode           SubscriptionMode           `json:"mode"`
+	DigestInterval SubscriptionDigestInterval `json:"digest_interval"`
+	Priority       int                        `json:"priority" gorm:"default:3"`
+	LastDigestAt   *time.Time                 `json:"last_digest_at"`
 }
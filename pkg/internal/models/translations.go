@@ -0,0 +1,16 @@
+package models
+
+import "git.solsynth.dev/hypernet/nexus/pkg/nex/cruda"
+
+// PostTranslation is the cached translation of a post.
+// The ContentHash is the hash of the original body, the translation is outdated once the hash changed.
+type PostTranslation struct {
+	cruda.BaseModel
+
+	Language    string `json:"language" gorm:"uniqueIndex:idx_post_translation,priority:2"`
+	ContentHash string `json:"content_hash" gorm:"uniqueIndex:idx_post_translation,priority:3;size:64"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Content     string `json:"content"`
+	PostID      uint   `json:"post_id" gorm:"uniqueIndex:idx_post_translation,priority:1"`
+}
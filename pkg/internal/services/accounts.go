@@ -9,6 +9,7 @@ import (
 	"git.solsynth.dev/hypernet/passport/pkg/authkit"
 	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 func GetAccountWithID(id uint) (models.Publisher, error) {
@@ -19,26 +20,24 @@ func GetAccountWithID(id uint) (models.Publisher, error) {
 	return account, nil
 }
 
-func ModifyPosterVoteCount(user models.Publisher, isUpvote bool, delta int) error {
+func ModifyPosterVoteCount(tx *gorm.DB, user models.Publisher, isUpvote bool, delta int) error {
 	if isUpvote {
-		user.TotalUpvote += delta
-		return database.C.Model(&user).Update("total_upvote", user.TotalUpvote).Error
+		return tx.Model(&user).Update("total_upvote", gorm.Expr("total_upvote + ?", delta)).Error
 	} else {
-		user.TotalDownvote += delta
-		return database.C.Model(&user).Update("total_downvote", user.TotalDownvote).Error
+		return tx.Model(&user).Update("total_downvote", gorm.Expr("total_downvote + ?", delta)).Error
 	}
 }
 
-func NotifyPosterAccount(pub models.Publisher, post models.Post, title, body, topic string, subtitle ...string) error {
-	if pub.AccountID == nil {
-		return nil
-	}
+func ModifyPublisherFollowerCount(tx *gorm.DB, user models.Publisher, delta int) error {
+	return tx.Model(&user).Update("total_follower", gorm.Expr("total_follower + ?", delta)).Error
+}
 
+func BuildPosterNotification(pub models.Publisher, post models.Post, title, body, topic string, subtitle ...string) pushkit.Notification {
 	if len(subtitle) == 0 {
 		subtitle = append(subtitle, "")
 	}
 
-	err := authkit.NotifyUser(gap.Nx, uint64(*pub.AccountID), pushkit.Notification{
+	return pushkit.Notification{
 		Topic:    topic,
 		Title:    title,
 		Subtitle: subtitle[0],
@@ -48,7 +47,15 @@ func NotifyPosterAccount(pub models.Publisher, post models.Post, title, body, to
 			"related_post": TruncatePostContent(post),
 			"avatar":       pub.Avatar,
 		},
-	})
+	}
+}
+
+func NotifyPosterAccount(pub models.Publisher, post models.Post, title, body, topic string, subtitle ...string) error {
+	if pub.AccountID == nil {
+		return nil
+	}
+
+	err := authkit.NotifyUser(gap.Nx, uint64(*pub.AccountID), BuildPosterNotification(pub, post, title, body, topic, subtitle...))
 	if err != nil {
 		log.Warn().Err(err).Msg("An error occurred when notify account...")
 	} else {
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// AnnotationContextLength is how many runes before and after the quote are kept to anchor the annotation.
+const AnnotationContextLength = 32
+
+func GetArticleContent(post models.Post) string {
+	content, _ := post.Body["content"].(string)
+	return content
+}
+
+// FilterAnnotationWithUserContext will only keep the annotations the user can see.
+// Everyone can see the public ones, the publisher of the post can also see the author-only ones.
+func FilterAnnotationWithUserContext(tx *gorm.DB, post models.Post, user *authm.Account) *gorm.DB {
+	if user == nil {
+		return tx.Where("visibility = ?", models.AnnotationVisibilityPublic)
+	}
+
+	if post.Publisher.AccountID != nil && *post.Publisher.AccountID == user.ID {
+		return tx.Where("account_id = ? OR visibility != ?", user.ID, models.AnnotationVisibilityPrivate)
+	}
+	return tx.Where("account_id = ? OR visibility = ?", user.ID, models.AnnotationVisibilityPublic)
+}
+
+func CountAnnotation(tx *gorm.DB) (int64, error) {
+	var count int64
+	if err := tx.Model(&models.Annotation{}).Count(&count).Error; err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func ListAnnotation(tx *gorm.DB, take int, offset int) ([]models.Annotation, error) {
+	if take > 100 {
+		take = 100
+	}
+
+	var items []models.Annotation
+	if err := tx.
+		Limit(take).Offset(offset).
+		Order("start_offset ASC, created_at ASC").
+		Find(&items).Error; err != nil {
+		return items, err
+	}
+
+	return items, nil
+}
+
+// NewAnnotation will anchor the annotation to the passage in the current content of the article.
+// The quote is always taken from the content, the client only decides the range.
+func NewAnnotation(user authm.Account, post models.Post, item models.Annotation) (models.Annotation, error) {
+	content := []rune(GetArticleContent(post))
+	if item.StartOffset < 0 || item.EndOffset > len(content) || item.StartOffset >= item.EndOffset {
+		return item, fmt.Errorf("annotation range is out of the content")
+	}
+
+	item.Quote = string(content[item.StartOffset:item.EndOffset])
+	item.Prefix = string(content[max(item.StartOffset-AnnotationContextLength, 0):item.StartOffset])
+	item.Suffix = string(content[item.EndOffset:min(item.EndOffset+AnnotationContextLength, len(content))])
+	item.PostID = post.ID
+	item.AccountID = user.ID
+
+	if err := database.C.Create(&item).Error; err != nil {
+		return item, err
+	}
+
+	if item.Visibility == models.AnnotationVisibilityPublic &&
+		post.Publisher.AccountID != nil && *post.Publisher.AccountID != user.ID {
+		err := NotifyPosterAccount(
+			post.Publisher,
+			post,
+			"Post got annotated",
+			fmt.Sprintf("%s (%s) annotated \"%s\" in your post.", user.Nick, user.Name, TruncatePostContentShort(item.Quote)),
+			"interactive.feedback",
+			fmt.Sprintf("%s annotated your post", user.Nick),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("An error occurred when notifying user...")
+		}
+	}
+
+	return item, nil
+}
+
+func ResolveAnnotation(item models.Annotation) (models.Annotation, error) {
+	if item.ResolvedAt != nil {
+		item.ResolvedAt = nil
+	} else {
+		item.ResolvedAt = lo.ToPtr(time.Now())
+	}
+
+	if err := database.C.Model(&item).Update("resolved_at", item.ResolvedAt).Error; err != nil {
+		return item, err
+	}
+	return item, nil
+}
+
+func DeleteAnnotation(item models.Annotation) error {
+	return database.C.Delete(&item).Error
+}
+
+// AnchorAnnotation will find the passage of the annotation in the content.
+// The original range is used when it still contains the quote, otherwise the occurrence of the quote
+// with the most similar surroundings is used. When the quote cannot be found, the annotation will be orphaned.
+func AnchorAnnotation(content string, item models.Annotation) models.Annotation {
+	runes := []rune(content)
+	quote := []rune(item.Quote)
+
+	if item.StartOffset >= 0 && item.EndOffset <= len(runes) && item.StartOffset < item.EndOffset &&
+		string(runes[item.StartOffset:item.EndOffset]) == item.Quote {
+		item.IsOrphaned = false
+		return item
+	}
+
+	best, bestScore := -1, -1
+	for start := 0; start+len(quote) <= len(runes) && len(quote) > 0; start++ {
+		if string(runes[start:start+len(quote)]) != item.Quote {
+			continue
+		}
+
+		prefix := string(runes[max(start-AnnotationContextLength, 0):start])
+		suffix := string(runes[start+len(quote) : min(start+len(quote)+AnnotationContextLength, len(runes))])
+		score := commonSuffixLength(prefix, item.Prefix) + commonPrefixLength(suffix, item.Suffix)
+		if score > bestScore || (score == bestScore && abs(start-item.StartOffset) < abs(best-item.StartOffset)) {
+			best, bestScore = start, score
+		}
+	}
+
+	if best < 0 {
+		item.IsOrphaned = true
+		return item
+	}
+
+	item.StartOffset = best
+	item.EndOffset = best + len(quote)
+	item.Prefix = string(runes[max(best-AnnotationContextLength, 0):best])
+	item.Suffix = string(runes[item.EndOffset:min(item.EndOffset+AnnotationContextLength, len(runes))])
+	item.IsOrphaned = false
+	return item
+}
+
+// ReanchorPostAnnotations will anchor all the annotations of the post again after the content was edited.
+func ReanchorPostAnnotations(post models.Post) error {
+	var items []models.Annotation
+	if err := database.C.Where("post_id = ?", post.ID).Find(&items).Error; err != nil {
+		return err
+	}
+
+	content := GetArticleContent(post)
+	for _, item := range items {
+		anchored := AnchorAnnotation(content, item)
+		if anchored == item {
+			continue
+		}
+		if err := database.C.Model(&anchored).Select(
+			"start_offset", "end_offset", "prefix", "suffix", "is_orphaned",
+		).Updates(&anchored).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func commonPrefixLength(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	var count int
+	for count < len(ra) && count < len(rb) && ra[count] == rb[count] {
+		count++
+	}
+	return count
+}
+
+func commonSuffixLength(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	var count int
+	for count < len(ra) && count < len(rb) && ra[len(ra)-1-count] == rb[len(rb)-1-count] {
+		count++
+	}
+	return count
+}
+
+func abs(x int) int {
+	return lo.Ternary(x < 0, -x, x)
+}
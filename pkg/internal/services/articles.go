@@ -0,0 +1,74 @@
+package services
+
+import (
+	"math"
+	"unicode"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/samber/lo"
+)
+
+const (
+	// ArticleReadingSpeedWords is the words read per minute for the languages separated by space
+	ArticleReadingSpeedWords = 230
+	// ArticleReadingSpeedCJK is the characters read per minute for Chinese and Japanese
+	ArticleReadingSpeedCJK = 500
+)
+
+func isCJKRune(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r)
+}
+
+// CountWords will count the words in the plain text.
+// The Chinese and Japanese has no space between words, so each character of them was counted as a word.
+func CountWords(content string) (words int, cjk int) {
+	inWord := false
+	for _, r := range content {
+		switch {
+		case isCJKRune(r):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || (r == '\'' && inWord):
+			if !inWord {
+				words++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	return words, cjk
+}
+
+// EstimateReadingTime will return the reading time in minutes, rounded up.
+func EstimateReadingTime(words int, cjk int) int {
+	if words+cjk == 0 {
+		return 0
+	}
+	minutes := float64(words)/ArticleReadingSpeedWords + float64(cjk)/ArticleReadingSpeedCJK
+	return max(1, int(math.Ceil(minutes)))
+}
+
+// ComputeArticleMetadata will put the table of contents, word count and reading time into the body of the article.
+func ComputeArticleMetadata(item models.Post) {
+	if item.Type != models.PostTypeArticle || item.Body == nil {
+		return
+	}
+	content, ok := item.Body["content"].(string)
+	if !ok {
+		return
+	}
+
+	words, cjk := CountWords(RenderMarkdownPlainText(content))
+	item.Body["toc"] = ListMarkdownHeading(content)
+	item.Body["word_count"] = words + cjk
+	item.Body["reading_time"] = EstimateReadingTime(words, cjk)
+}
+
+// ListArticleAnchors will return the heading anchors of the article, they are the anchors can be reacted.
+func ListArticleAnchors(item models.Post) []string {
+	content, _ := item.Body["content"].(string)
+	return lo.Map(ListMarkdownHeading(content), func(entry models.PostTableOfContentEntry, _ int) string {
+		return entry.Anchor
+	})
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/samber/lo"
+)
+
+const ArticleBlocksMax = 2048
+
+var articleCalloutVariants = []string{"note", "tip", "important", "warning", "caution"}
+
+var (
+	articleFenceRegex    = regexp.MustCompile("^(`{3,}|~{3,})[ \t]*([^`\\s]*)[ \t]*$")
+	articleHeadingRegex  = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t]*$`)
+	articleCalloutRegex  = regexp.MustCompile(`^\[!(NOTE|TIP|IMPORTANT|WARNING|CAUTION)\][ \t]*$`)
+	articleImageRegex    = regexp.MustCompile(`^!\[([^\]\n]*)\]\(attachment://([^\s)]+)\)$`)
+	articleEmbedRegex    = regexp.MustCompile(`^@\[embed\]\(([^\s)]+)\)$`)
+	articleLanguageRegex = regexp.MustCompile(`^[\w+#.-]*$`)
+)
+
+// ValidateArticleBlocks will check the blocks are well-formed,
+// and can be converted to markdown and back without losing anything.
+func ValidateArticleBlocks(blocks []models.ArticleBlock) error {
+	if len(blocks) > ArticleBlocksMax {
+		return fmt.Errorf("too many blocks, the maximum is %d", ArticleBlocksMax)
+	}
+
+	for idx, block := range blocks {
+		switch block.Type {
+		case models.ArticleBlockParagraph, models.ArticleBlockQuote:
+			if len(strings.TrimSpace(block.Text)) == 0 {
+				return fmt.Errorf("block %d: text is required", idx)
+			}
+		case models.ArticleBlockHeading:
+			if block.Level < 1 || block.Level > 6 {
+				return fmt.Errorf("block %d: heading level must be between 1 and 6", idx)
+			} else if len(strings.TrimSpace(block.Text)) == 0 || strings.Contains(block.Text, "\n") {
+				return fmt.Errorf("block %d: heading text must be a non-empty single line", idx)
+			}
+		case models.ArticleBlockCode:
+			if !articleLanguageRegex.MatchString(block.Language) {
+				return fmt.Errorf("block %d: invalid code language", idx)
+			}
+		case models.ArticleBlockImage:
+			if len(block.Attachment) == 0 {
+				return fmt.Errorf("block %d: attachment is required", idx)
+			}
+		case models.ArticleBlockEmbed:
+			if uri, err := url.Parse(block.URL); err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || len(uri.Host) == 0 {
+				return fmt.Errorf("block %d: embed url must be a http or https url", idx)
+			}
+		case models.ArticleBlockCallout:
+			if !lo.Contains(articleCalloutVariants, block.Variant) {
+				return fmt.Errorf("block %d: callout variant must be one of %s", idx, strings.Join(articleCalloutVariants, ", "))
+			}
+		default:
+			return fmt.Errorf("block %d: unknown block type %s", idx, block.Type)
+		}
+	}
+
+	parsed := ParseArticleBlocks(RenderArticleBlocks(blocks))
+	for idx := range blocks {
+		if idx >= len(parsed) || parsed[idx] != blocks[idx] {
+			return fmt.Errorf("block %d cannot be converted to markdown losslessly", idx)
+		}
+	}
+	if len(parsed) != len(blocks) {
+		return fmt.Errorf("blocks cannot be converted to markdown losslessly")
+	}
+
+	return nil
+}
+
+// ListArticleBlockAttachment will return the attachments referenced by the image blocks.
+func ListArticleBlockAttachment(blocks []models.ArticleBlock) []string {
+	var out []string
+	for _, block := range blocks {
+		if block.Type == models.ArticleBlockImage {
+			out = append(out, block.Attachment)
+		}
+	}
+	return out
+}
+
+func prefixArticleQuote(text string) string {
+	lines := strings.Split(text, "\n")
+	for idx, line := range lines {
+		if len(line) == 0 {
+			lines[idx] = ">"
+		} else {
+			lines[idx] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// RenderArticleBlocks will convert the blocks into markdown.
+// Images were written as the attachment:// link, and embeds were written as @[embed](url).
+func RenderArticleBlocks(blocks []models.ArticleBlock) string {
+	parts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case models.ArticleBlockHeading:
+			parts = append(parts, strings.Repeat("#", block.Level)+" "+block.Text)
+		case models.ArticleBlockCode:
+			fence := "```"
+			for strings.Contains(block.Text, fence) {
+				fence += "`"
+			}
+			parts = append(parts, fence+block.Language+"\n"+block.Text+"\n"+fence)
+		case models.ArticleBlockQuote:
+			parts = append(parts, prefixArticleQuote(block.Text))
+		case models.ArticleBlockCallout:
+			parts = append(parts, "> [!"+strings.ToUpper(block.Variant)+"]\n"+prefixArticleQuote(block.Text))
+		case models.ArticleBlockImage:
+			parts = append(parts, "!["+block.Caption+"](attachment://"+block.Attachment+")")
+		case models.ArticleBlockEmbed:
+			parts = append(parts, "@[embed]("+block.URL+")")
+		default:
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ParseArticleBlocks will split the markdown into blocks.
+// The markdown syntax that has no matching block, like lists and tables, will be kept in the paragraph as is.
+func ParseArticleBlocks(content string) []models.ArticleBlock {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	blocks := make([]models.ArticleBlock, 0)
+
+	isQuote := func(line string) bool { return strings.HasPrefix(line, ">") }
+	interrupts := func(line string) bool {
+		return articleFenceRegex.MatchString(line) || articleHeadingRegex.MatchString(line) || isQuote(line)
+	}
+
+	for idx := 0; idx < len(lines); {
+		line := lines[idx]
+		if len(strings.TrimSpace(line)) == 0 {
+			idx++
+			continue
+		}
+
+		if match := articleFenceRegex.FindStringSubmatch(line); match != nil {
+			fence := match[1]
+			end := idx + 1
+			for ; end < len(lines); end++ {
+				trimmed := strings.TrimSpace(lines[end])
+				if strings.HasPrefix(trimmed, fence) && len(strings.Trim(trimmed, fence[:1])) == 0 {
+					break
+				}
+			}
+			blocks = append(blocks, models.ArticleBlock{
+				Type:     models.ArticleBlockCode,
+				Language: match[2],
+				Text:     strings.Join(lines[idx+1:min(end, len(lines))], "\n"),
+			})
+			idx = end + 1
+			continue
+		}
+
+		if match := articleHeadingRegex.FindStringSubmatch(line); match != nil {
+			blocks = append(blocks, models.ArticleBlock{
+				Type:  models.ArticleBlockHeading,
+				Level: len(match[1]),
+				Text:  match[2],
+			})
+			idx++
+			continue
+		}
+
+		if isQuote(line) {
+			var quoted []string
+			for ; idx < len(lines) && isQuote(lines[idx]); idx++ {
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(lines[idx], ">"), " "))
+			}
+			if match := articleCalloutRegex.FindStringSubmatch(quoted[0]); match != nil {
+				blocks = append(blocks, models.ArticleBlock{
+					Type:    models.ArticleBlockCallout,
+					Variant: strings.ToLower(match[1]),
+					Text:    strings.Join(quoted[1:], "\n"),
+				})
+			} else {
+				blocks = append(blocks, models.ArticleBlock{
+					Type: models.ArticleBlockQuote,
+					Text: strings.Join(quoted, "\n"),
+				})
+			}
+			continue
+		}
+
+		start := idx
+		for idx++; idx < len(lines) && len(strings.TrimSpace(lines[idx])) > 0 && !interrupts(lines[idx]); idx++ {
+		}
+		text := strings.Join(lines[start:idx], "\n")
+
+		if match := articleImageRegex.FindStringSubmatch(text); match != nil {
+			blocks = append(blocks, models.ArticleBlock{
+				Type:       models.ArticleBlockImage,
+				Caption:    match[1],
+				Attachment: match[2],
+			})
+		} else if match := articleEmbedRegex.FindStringSubmatch(text); match != nil {
+			blocks = append(blocks, models.ArticleBlock{
+				Type: models.ArticleBlockEmbed,
+				URL:  match[1],
+			})
+		} else {
+			blocks = append(blocks, models.ArticleBlock{
+				Type: models.ArticleBlockParagraph,
+				Text: text,
+			})
+		}
+	}
+
+	return blocks
+}
+
+// HydrateArticleBlocks will fill the blocks of the articles created before the block format.
+func HydrateArticleBlocks(post *models.Post) {
+	if post.Type != models.PostTypeArticle {
+		return
+	}
+	if _, ok := post.Body["blocks"]; ok {
+		return
+	}
+	if content, ok := post.Body["content"].(string); ok {
+		post.Body["blocks"] = ParseArticleBlocks(content)
+	}
+}
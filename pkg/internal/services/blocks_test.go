@@ -0,0 +1,114 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+)
+
+func TestArticleBlocksRoundTrip(t *testing.T) {
+	cases := map[string][]models.ArticleBlock{
+		"paragraph": {
+			{Type: models.ArticleBlockParagraph, Text: "Hello **world**\nthe second line"},
+		},
+		"heading": {
+			{Type: models.ArticleBlockHeading, Level: 1, Text: "Title"},
+			{Type: models.ArticleBlockHeading, Level: 6, Text: "你好 世界"},
+		},
+		"code": {
+			{Type: models.ArticleBlockCode, Language: "go", Text: "func main() {\n\n\tprintln(\"hi\")\n}"},
+			{Type: models.ArticleBlockCode, Text: "a fence inside\n```\nstill code"},
+		},
+		"quote": {
+			{Type: models.ArticleBlockQuote, Text: "quoted\n\nwith a blank line"},
+		},
+		"callout": {
+			{Type: models.ArticleBlockCallout, Variant: "warning", Text: "be careful"},
+			{Type: models.ArticleBlockCallout, Variant: "note", Text: "line one\nline two"},
+		},
+		"image": {
+			{Type: models.ArticleBlockImage, Attachment: "abc123", Caption: "a cat"},
+			{Type: models.ArticleBlockImage, Attachment: "def456"},
+		},
+		"embed": {
+			{Type: models.ArticleBlockEmbed, URL: "https://example.com/video?id=1"},
+		},
+		"mixed": {
+			{Type: models.ArticleBlockHeading, Level: 2, Text: "Intro"},
+			{Type: models.ArticleBlockParagraph, Text: "- a list\n- kept as is"},
+			{Type: models.ArticleBlockQuote, Text: "quote"},
+			{Type: models.ArticleBlockCallout, Variant: "tip", Text: "tip"},
+			{Type: models.ArticleBlockCode, Language: "c++", Text: "int x;"},
+			{Type: models.ArticleBlockImage, Attachment: "abc123", Caption: "cover"},
+			{Type: models.ArticleBlockEmbed, URL: "http://example.com"},
+			{Type: models.ArticleBlockParagraph, Text: "The end."},
+		},
+	}
+
+	for name, blocks := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateArticleBlocks(blocks); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			markdown := RenderArticleBlocks(blocks)
+			parsed := ParseArticleBlocks(markdown)
+			if !reflect.DeepEqual(parsed, blocks) {
+				t.Errorf("expected the blocks to survive the round trip\nmarkdown: %q\ngot: %+v", markdown, parsed)
+			}
+			if again := RenderArticleBlocks(parsed); again != markdown {
+				t.Errorf("expected the same markdown, got %q and %q", markdown, again)
+			}
+		})
+	}
+}
+
+func TestValidateArticleBlocksRejects(t *testing.T) {
+	cases := map[string]models.ArticleBlock{
+		"unknown type":        {Type: "table", Text: "x"},
+		"empty paragraph":     {Type: models.ArticleBlockParagraph, Text: "  "},
+		"empty quote":         {Type: models.ArticleBlockQuote},
+		"heading level zero":  {Type: models.ArticleBlockHeading, Level: 0, Text: "x"},
+		"heading level seven": {Type: models.ArticleBlockHeading, Level: 7, Text: "x"},
+		"multiline heading":   {Type: models.ArticleBlockHeading, Level: 1, Text: "a\nb"},
+		"code language":       {Type: models.ArticleBlockCode, Language: "go lang", Text: "x"},
+		"image attachment":    {Type: models.ArticleBlockImage, Caption: "x"},
+		"embed scheme":        {Type: models.ArticleBlockEmbed, URL: "javascript:alert(1)"},
+		"embed host":          {Type: models.ArticleBlockEmbed, URL: "https://"},
+		"callout variant":     {Type: models.ArticleBlockCallout, Variant: "danger", Text: "x"},
+		"paragraph as header": {Type: models.ArticleBlockParagraph, Text: "# not a paragraph"},
+		"paragraph as quote":  {Type: models.ArticleBlockParagraph, Text: "> not a paragraph"},
+		"paragraph with gap":  {Type: models.ArticleBlockParagraph, Text: "one\n\ntwo"},
+		"heading padding":     {Type: models.ArticleBlockHeading, Level: 1, Text: "title "},
+	}
+
+	for name, block := range cases {
+		if err := ValidateArticleBlocks([]models.ArticleBlock{block}); err == nil {
+			t.Errorf("%s: expected the block %+v to be rejected", name, block)
+		}
+	}
+
+	tooMany := make([]models.ArticleBlock, ArticleBlocksMax+1)
+	for idx := range tooMany {
+		tooMany[idx] = models.ArticleBlock{Type: models.ArticleBlockParagraph, Text: "x"}
+	}
+	if err := ValidateArticleBlocks(tooMany); err == nil {
+		t.Error("expected too many blocks to be rejected")
+	}
+}
+
+func TestParseArticleBlocksFromMarkdown(t *testing.T) {
+	markdown := "# Title\n\nSome text\nwrapped\n## Sub\n> [!NOTE]\n> remember\n\n![](attachment://xyz)\n\n@[embed](https://example.com)\n\n```\nunterminated"
+	expected := []models.ArticleBlock{
+		{Type: models.ArticleBlockHeading, Level: 1, Text: "Title"},
+		{Type: models.ArticleBlockParagraph, Text: "Some text\nwrapped"},
+		{Type: models.ArticleBlockHeading, Level: 2, Text: "Sub"},
+		{Type: models.ArticleBlockCallout, Variant: "note", Text: "remember"},
+		{Type: models.ArticleBlockImage, Attachment: "xyz"},
+		{Type: models.ArticleBlockEmbed, URL: "https://example.com"},
+		{Type: models.ArticleBlockCode, Text: "unterminated"},
+	}
+	if parsed := ParseArticleBlocks(markdown); !reflect.DeepEqual(parsed, expected) {
+		t.Errorf("unexpected blocks %+v", parsed)
+	}
+}
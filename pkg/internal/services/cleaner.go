@@ -2,6 +2,7 @@ package services
 
 import (
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"github.com/rs/zerolog/log"
 	"time"
 )
@@ -19,5 +20,19 @@ func DoAutoDatabaseCleanup() {
 		count += tx.RowsAffected
 	}
 
+	// Sent notifications in the outbox are no longer needed, the dead ones are kept for investigating
+	tx := database.C.Unscoped().Delete(&models.NotificationOutbox{}, "sent_at < ?", time.Now().Add(-7*24*time.Hour))
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("An error occurred when cleaning up notification outbox...")
+	}
+	count += tx.RowsAffected
+
+	// The insight usages are only counted in the rate limit window
+	tx = database.C.Unscoped().Delete(&models.InsightUsage{}, "created_at < ?", time.Now().Add(-InsightRateWindow))
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("An error occurred when cleaning up insight usages...")
+	}
+	count += tx.RowsAffected
+
 	log.Debug().Int64("affected", count).Msg("Clean up entire database accomplished.")
 }
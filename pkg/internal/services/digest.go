@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionDigestMaxPosts   = 50
+	SubscriptionDigestMaxPreview = 5
+)
+
+func GetSubscriptionDigestDuration(interval models.SubscriptionDigestInterval) time.Duration {
+	switch interval {
+	case models.SubscriptionDigestWeekly:
+		return 7 * 24 * time.Hour
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func ListSubscriptionDigestPost(subscription models.Subscription, since, until time.Time) ([]models.Post, error) {
+	tx := FilterPostDraft(database.C)
+	tx = FilterPostReply(tx)
+	tx = FilterPostWithPublishedAt(tx, until)
+	tx = tx.
+		Where("published_at > ? AND published_at <= ?", since, until).
+		Where("visibility != ?", models.PostVisibilityNone)
+
+	switch {
+	case subscription.AccountID != nil:
+		tx = tx.Where("publisher_id = ?", *subscription.AccountID)
+	case subscription.TagID != nil:
+		tx = tx.Where("id IN (?)", database.C.Table("post_tags").Select("post_id").Where("tag_id = ?", *subscription.TagID))
+	case subscription.CategoryID != nil:
+		tx = tx.Where("id IN (?)", database.C.Table("post_categories").Select("post_id").Where("category_id = ?", *subscription.CategoryID))
+	case subscription.SeriesID != nil:
+		tx = tx.Where("series_id = ?", *subscription.SeriesID)
+	default:
+		return nil, nil
+	}
+
+	var posts []models.Post
+	if err := tx.
+		Preload("Publisher").
+		Limit(SubscriptionDigestMaxPosts).
+		Order("published_at DESC").
+		Find(&posts).Error; err != nil {
+		return posts, err
+	}
+
+	return posts, nil
+}
+
+// SendSubscriptionDigest will collect the posts since the last digest of each due digest subscription.
+// And then merge them by follower, every follower will only receive one notification no matter how many subscriptions are due.
+func SendSubscriptionDigest() {
+	now := time.Now()
+	log.Debug().Time("now", now).Msg("Now sending subscription digests...")
+
+	var subscriptions []models.Subscription
+	if err := database.C.Where("mode = ?", models.SubscriptionModeDigest).Find(&subscriptions).Error; err != nil {
+		log.Error().Err(err).Msg("An error occurred when listing digest subscriptions...")
+		return
+	}
+
+	subscriptions = lo.Filter(subscriptions, func(item models.Subscription, index int) bool {
+		last := lo.FromPtrOr(item.LastDigestAt, item.CreatedAt)
+		return now.Sub(last) >= GetSubscriptionDigestDuration(item.DigestInterval)
+	})
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	type digestCandidate struct {
+		Post      models.Post
+		Followers map[uint64]int
+	}
+
+	// The posts were collected first, so the visibility of each post is resolved once for all its followers
+	// Only the processed subscriptions were advanced, the failed ones will be retried with the same posts next time
+	candidates := make(map[uint]*digestCandidate)
+	var processed []uint
+	for _, subscription := range subscriptions {
+		last := lo.FromPtrOr(subscription.LastDigestAt, subscription.CreatedAt)
+		posts, err := ListSubscriptionDigestPost(subscription, last, now)
+		if err != nil {
+			log.Error().Err(err).Uint("subscription", subscription.ID).Msg("An error occurred when listing digest posts...")
+			continue
+		}
+		processed = append(processed, subscription.ID)
+
+		for _, post := range posts {
+			if _, ok := candidates[post.ID]; !ok {
+				candidates[post.ID] = &digestCandidate{Post: post, Followers: make(map[uint64]int)}
+			}
+			follower := uint64(subscription.FollowerID)
+			candidate := candidates[post.ID]
+			candidate.Followers[follower] = max(candidate.Followers[follower], subscription.Priority)
+		}
+	}
+
+	type digestState struct {
+		Posts    map[uint]models.Post
+		Priority int
+	}
+
+	digests := make(map[uint]*digestState)
+	for _, candidate := range candidates {
+		for _, follower := range FilterPostNotifiableUser(candidate.Post, lo.Keys(candidate.Followers)) {
+			if _, ok := digests[uint(follower)]; !ok {
+				digests[uint(follower)] = &digestState{Posts: make(map[uint]models.Post)}
+			}
+			state := digests[uint(follower)]
+			state.Posts[candidate.Post.ID] = candidate.Post
+			state.Priority = max(state.Priority, candidate.Followers[follower])
+		}
+	}
+
+	// Followers received the same posts with the same priority will be sent in one batch
+	type digestGroup struct {
+		Posts    []models.Post
+		Priority int
+		UserIDs  []uint64
+	}
+
+	groups := make(map[string]*digestGroup)
+	for follower, state := range digests {
+		posts := lo.Values(state.Posts)
+		sort.Slice(posts, func(i, j int) bool {
+			return posts[i].ID > posts[j].ID
+		})
+		key := fmt.Sprintf("%d#%v", state.Priority, lo.Map(posts, func(item models.Post, index int) uint {
+			return item.ID
+		}))
+		if _, ok := groups[key]; !ok {
+			groups[key] = &digestGroup{Posts: posts, Priority: state.Priority}
+		}
+		groups[key].UserIDs = append(groups[key].UserIDs, uint64(follower))
+	}
+
+	if err := database.C.Transaction(func(tx *gorm.DB) error {
+		for _, group := range groups {
+			if err := EnqueueNotification(tx, group.UserIDs, pushkit.Notification{
+				Topic:    "interactive.subscription.digest",
+				Title:    fmt.Sprintf("%d new posts from your subscriptions", len(group.Posts)),
+				Subtitle: "Your subscription digest",
+				Body:     RenderSubscriptionDigestBody(group.Posts),
+				Priority: group.Priority,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if len(processed) == 0 {
+			return nil
+		}
+		return tx.Model(&models.Subscription{}).
+			Where("id IN ?", processed).
+			Update("last_digest_at", now).Error
+	}); err != nil {
+		log.Error().Err(err).Msg("An error occurred when enqueuing subscription digests...")
+		return
+	}
+
+	log.Debug().Int("subscriptions", len(processed)).Int("users", len(digests)).Msg("Send subscription digests accomplished.")
+}
+
+func RenderSubscriptionDigestBody(posts []models.Post) string {
+	var lines []string
+	for idx, post := range posts {
+		if idx >= SubscriptionDigestMaxPreview {
+			lines = append(lines, fmt.Sprintf("And %d more...", len(posts)-idx))
+			break
+		}
+
+		preview := ""
+		if val, ok := post.Body["title"].(string); ok && len(val) > 0 {
+			preview = val
+		} else if val, ok := post.Body["content"].(string); ok {
+			preview = TruncatePostContentShort(val)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", post.Publisher.Nick, preview))
+	}
+
+	return strings.Join(lines, "\n")
+}
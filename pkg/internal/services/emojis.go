@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	pproto "git.solsynth.dev/hypernet/paperclip/pkg/proto"
+	"git.solsynth.dev/hypernet/passport/pkg/authkit"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/rivo/uniseg"
+	"github.com/samber/lo"
+)
+
+// BuiltinReactionEmojis is the catalog of the named reactions can be used everywhere.
+// Besides them, any single unicode emoji can be used as a reaction, see ResolveReactionEmoji.
+var BuiltinReactionEmojis = map[string]models.ReactionEmoji{
+	"thumb_up":   {Symbol: "thumb_up", Icon: "👍", Attitude: models.AttitudePositive},
+	"thumb_down": {Symbol: "thumb_down", Icon: "👎", Attitude: models.AttitudeNegative},
+	"just_okay":  {Symbol: "just_okay", Icon: "😅", Attitude: models.AttitudeNeutral},
+	"cry":        {Symbol: "cry", Icon: "😭", Attitude: models.AttitudeNeutral},
+	"confuse":    {Symbol: "confuse", Icon: "🧐", Attitude: models.AttitudeNeutral},
+	"clap":       {Symbol: "clap", Icon: "👏", Attitude: models.AttitudePositive},
+	"laugh":      {Symbol: "laugh", Icon: "😂", Attitude: models.AttitudePositive},
+	"angry":      {Symbol: "angry", Icon: "😡", Attitude: models.AttitudeNegative},
+	"party":      {Symbol: "party", Icon: "🎉", Attitude: models.AttitudePositive},
+	"pray":       {Symbol: "pray", Icon: "🙏", Attitude: models.AttitudePositive},
+	"heart":      {Symbol: "heart", Icon: "❤️", Attitude: models.AttitudePositive},
+}
+
+// emojiRanges are the code points can start an emoji, or be a part of an emoji sequence.
+var emojiRanges = [][2]rune{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x21AA}, {0x231A, 0x23FF},
+	{0x24C2, 0x24C2}, {0x25AA, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B55}, {0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3299},
+	{0x1F000, 0x1FAFF},
+}
+
+// emojiModifiers are the code points only valid after the first code point of an emoji sequence,
+// they are the zero width joiner, the variation selectors, the keycap and the tags of the subdivision flags.
+var emojiModifiers = [][2]rune{
+	{0x200D, 0x200D}, {0xFE0E, 0xFE0F}, {0x20E3, 0x20E3}, {0xE0020, 0xE007F},
+}
+
+func inRuneRanges(r rune, ranges [][2]rune) bool {
+	for _, item := range ranges {
+		if r >= item[0] && r <= item[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnicodeEmoji will check the symbol is exactly one emoji, including the ZWJ sequences, the flags and the keycaps.
+func IsUnicodeEmoji(symbol string) bool {
+	cluster, rest, _, _ := uniseg.FirstGraphemeClusterInString(symbol, -1)
+	if len(cluster) == 0 || len(rest) > 0 {
+		return false
+	}
+
+	runes := []rune(cluster)
+	first := runes[0]
+	if (first >= '0' && first <= '9') || first == '#' || first == '*' {
+		// The keycaps start with a digit, and must end with the combining enclosing keycap
+		return runes[len(runes)-1] == 0x20E3 && lo.EveryBy(runes[1:], func(r rune) bool {
+			return inRuneRanges(r, emojiModifiers)
+		})
+	}
+	if !inRuneRanges(first, emojiRanges) {
+		return false
+	}
+	return lo.EveryBy(runes[1:], func(r rune) bool {
+		return inRuneRanges(r, emojiRanges) || inRuneRanges(r, emojiModifiers)
+	})
+}
+
+func GetRealmEmojiSymbol(emoji models.RealmEmoji) string {
+	return fmt.Sprintf("%d:%s", emoji.RealmID, emoji.Alias)
+}
+
+func ListBuiltinReactionEmoji() []models.ReactionEmoji {
+	emojis := lo.Values(BuiltinReactionEmojis)
+	sort.Slice(emojis, func(i, j int) bool {
+		return emojis[i].Symbol < emojis[j].Symbol
+	})
+	return emojis
+}
+
+func ListRealmEmoji(realmID uint) ([]models.RealmEmoji, error) {
+	var emojis []models.RealmEmoji
+	if err := database.C.Where("realm_id = ?", realmID).Order("alias ASC").Find(&emojis).Error; err != nil {
+		return emojis, err
+	}
+	return emojis, nil
+}
+
+func NewRealmEmoji(emoji models.RealmEmoji) (models.RealmEmoji, error) {
+	if _, ok := BuiltinReactionEmojis[emoji.Alias]; ok {
+		return emoji, fmt.Errorf("emoji alias %s is reserved by the built-in emoji", emoji.Alias)
+	}
+
+	var count int64
+	if err := database.C.Model(&models.RealmEmoji{}).
+		Where("realm_id = ? AND alias = ?", emoji.RealmID, emoji.Alias).
+		Count(&count).Error; err != nil {
+		return emoji, err
+	} else if count > 0 {
+		return emoji, fmt.Errorf("emoji alias %s already exists in this realm", emoji.Alias)
+	}
+
+	if err := checkRealmEmojiAttachment(emoji); err != nil {
+		return emoji, err
+	}
+
+	if err := database.C.Create(&emoji).Error; err != nil {
+		return emoji, err
+	}
+	return emoji, nil
+}
+
+// checkRealmEmojiAttachment will make the attachment of the emoji public.
+// Paperclip only updates the attachments exist and owned by the user, so nothing updated means the attachment is unusable.
+func checkRealmEmojiAttachment(emoji models.RealmEmoji) error {
+	conn, err := gap.Nx.GetClientGrpcConn("uc")
+	if err != nil {
+		return fmt.Errorf("failed to connect Paperclip: %v", err)
+	}
+
+	pc := pproto.NewAttachmentServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	resp, err := pc.UpdateVisibility(ctx, &pproto.UpdateVisibilityRequest{
+		Rid:         []string{emoji.Attachment},
+		UserId:      lo.ToPtr(uint64(emoji.AccountID)),
+		IsIndexable: true,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to check emoji attachment: %v", err)
+	} else if resp.Count == 0 {
+		return fmt.Errorf("attachment %s was not found", emoji.Attachment)
+	}
+
+	return nil
+}
+
+func DeleteRealmEmoji(emoji models.RealmEmoji) error {
+	return database.C.Delete(&emoji).Error
+}
+
+// ResolveReactionEmoji will find the emoji of the symbol in the catalog.
+// The unicode emoji can be used everywhere, the ones in the built-in catalog were stored with their names,
+// so they were counted together. Others were stored as is and treated as neutral.
+// The custom emoji can be used on the posts in the realm, or by the members of the realm.
+func ResolveReactionEmoji(symbol string, post models.Post, user authm.Account) (models.ReactionEmoji, error) {
+	if emoji, ok := BuiltinReactionEmojis[symbol]; ok {
+		return emoji, nil
+	}
+	if IsUnicodeEmoji(symbol) {
+		for _, emoji := range BuiltinReactionEmojis {
+			if strings.TrimSuffix(emoji.Icon, "\uFE0F") == strings.TrimSuffix(symbol, "\uFE0F") {
+				return emoji, nil
+			}
+		}
+		return models.ReactionEmoji{Symbol: symbol, Icon: symbol, Attitude: models.AttitudeNeutral}, nil
+	}
+
+	realmSegment, alias, ok := strings.Cut(symbol, ":")
+	if !ok {
+		return models.ReactionEmoji{}, fmt.Errorf("unknown reaction symbol: %s", symbol)
+	}
+	realmID, err := strconv.Atoi(realmSegment)
+	if err != nil {
+		return models.ReactionEmoji{}, fmt.Errorf("unknown reaction symbol: %s", symbol)
+	}
+
+	var emoji models.RealmEmoji
+	if err := database.C.Where("realm_id = ? AND alias = ?", realmID, alias).First(&emoji).Error; err != nil {
+		return models.ReactionEmoji{}, fmt.Errorf("unknown reaction symbol: %s", symbol)
+	}
+
+	if post.RealmID == nil || *post.RealmID != emoji.RealmID {
+		if !authkit.CheckRealmMemberPerm(gap.Nx, emoji.RealmID, int(user.ID), 0) {
+			return models.ReactionEmoji{}, fmt.Errorf("you need to be a member of the realm to use this reaction")
+		}
+	}
+
+	return models.ReactionEmoji{
+		Symbol:     GetRealmEmojiSymbol(emoji),
+		Attachment: emoji.Attachment,
+		Attitude:   emoji.Attitude,
+	}, nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import "testing"
+
+func TestIsUnicodeEmoji(t *testing.T) {
+	for _, symbol := range []string{
+		"👍",
+		"❤️",
+		"👍🏽",
+		"👩‍💻",
+		"👨‍👩‍👧‍👦",
+		"🇯🇵",
+		"🏴󠁧󠁢󠁳󠁣󠁴󠁿",
+		"1️⃣",
+		"#️⃣",
+		"©️",
+	} {
+		if !IsUnicodeEmoji(symbol) {
+			t.Errorf("expected %q to be an emoji", symbol)
+		}
+	}
+
+	for _, symbol := range []string{
+		"",
+		"a",
+		"1",
+		"thumb_up",
+		"👍👍",
+		"👍 ",
+		"中",
+		"<script>",
+		"‍",
+	} {
+		if IsUnicodeEmoji(symbol) {
+			t.Errorf("expected %q not to be an emoji", symbol)
+		}
+	}
+}
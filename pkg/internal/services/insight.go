@@ -2,8 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	iproto "git.solsynth.dev/hypernet/insight/pkg/proto"
@@ -11,14 +13,107 @@ import (
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm/clause"
 )
 
-func GeneratePostInsights(post models.Post, user uint) (string, error) {
-	var insight models.PostInsight
-	if err := database.C.Where("post_id = ?", post.ID).First(&insight).Error; err == nil {
-		return insight.Response, nil
+const (
+	InsightJobTimeout     = 3 * time.Minute
+	InsightJobConcurrency = 4
+	InsightRateLimit      = 10
+	InsightRateWindow     = time.Hour
+)
+
+// ErrInsightRateLimited is returned when the user requested too many generations in the window.
+var ErrInsightRateLimited = errors.New("too many insight generations, please try again later")
+
+// InsightGenerator generates the insight of the source text.
+// The onChunk callback receives the partial output, the generators that cannot stream call it once with the whole response.
+type InsightGenerator interface {
+	Generate(ctx context.Context, source string, user uint, onChunk func(string)) (string, error)
+}
+
+// GrpcInsightGenerator generates with the ai service, the service replies in one response.
+type GrpcInsightGenerator struct{}
+
+func (v GrpcInsightGenerator) Generate(ctx context.Context, source string, user uint, onChunk func(string)) (string, error) {
+	conn, err := gap.Nx.GetClientGrpcConn("ai")
+	if err != nil {
+		return "", fmt.Errorf("failed to connect Insight: %v", err)
+	}
+	ic := iproto.NewInsightServiceClient(conn)
+	resp, err := ic.GenerateInsight(ctx, &iproto.InsightRequest{
+		Source: source,
+		UserId: uint64(user),
+	})
+	if err != nil {
+		return "", err
+	}
+	onChunk(resp.Response)
+	return resp.Response, nil
+}
+
+// DefaultInsightGenerator is the backend used to generate insights, replace it with a stub in tests.
+var DefaultInsightGenerator InsightGenerator = GrpcInsightGenerator{}
+
+// insightJob is a running job in this instance, the partial output is kept for the streaming clients.
+type insightJob struct {
+	lock        sync.Mutex
+	partial     strings.Builder
+	subscribers []chan string
+	finished    bool
+}
+
+var (
+	insightJobs     = make(map[uint]*insightJob)
+	insightJobsLock sync.Mutex
+	insightSlots    = make(chan struct{}, InsightJobConcurrency)
+)
+
+func (v *insightJob) push(chunk string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.partial.WriteString(chunk)
+	for _, ch := range v.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			// The subscriber is too slow, it will read the whole result from the database at the end
+		}
+	}
+}
+
+func (v *insightJob) finish() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.finished = true
+	for _, ch := range v.subscribers {
+		close(ch)
+	}
+	v.subscribers = nil
+}
+
+// SubscribePostInsight will return the partial output generated so far and a channel of the following chunks.
+// The channel is closed once the job is finished. When the job is not running in this instance, ok is false.
+func SubscribePostInsight(insightId uint) (partial string, chunks <-chan string, ok bool) {
+	insightJobsLock.Lock()
+	job, ok := insightJobs[insightId]
+	insightJobsLock.Unlock()
+	if !ok {
+		return "", nil, false
 	}
 
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	ch := make(chan string, 64)
+	if job.finished {
+		close(ch)
+	} else {
+		job.subscribers = append(job.subscribers, ch)
+	}
+	return job.partial.String(), ch, true
+}
+
+func getPostInsightSource(post models.Post) string {
 	var compactBuilder []string
 	if val, ok := post.Body["title"].(string); ok && len(val) > 0 {
 		compactBuilder = append(compactBuilder, "Title: "+val)
@@ -30,31 +125,150 @@ func GeneratePostInsights(post models.Post, user uint) (string, error) {
 		compactBuilder = append(compactBuilder, val)
 	}
 
-	compact := strings.Join(compactBuilder, "\n")
+	return strings.Join(compactBuilder, "\n")
+}
 
-	conn, err := gap.Nx.GetClientGrpcConn("ai")
-	if err != nil {
-		return "", fmt.Errorf("failed to connect Insight: %v", err)
+// GetPostInsight will return the latest insight of the current post body.
+// The pending jobs that exceed the timeout are left by a stopped instance, they are treated as failed.
+func GetPostInsight(post models.Post) (models.PostInsight, error) {
+	var insight models.PostInsight
+	if err := database.C.
+		Where("post_id = ? AND content_hash = ?", post.ID, GetPostContentHash(post)).
+		Order("created_at DESC").
+		First(&insight).Error; err != nil {
+		return insight, err
 	}
-	ic := iproto.NewInsightServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
-	defer cancel()
-	resp, err := ic.GenerateInsight(ctx, &iproto.InsightRequest{
-		Source: compact,
-		UserId: uint64(user),
-	})
-	if err != nil {
-		return "", err
+
+	if insight.Status == models.PostInsightPending && time.Since(insight.CreatedAt) > InsightJobTimeout+time.Minute {
+		insight.Status = models.PostInsightFailed
+		insight.Error = "generation was interrupted"
+		database.C.Model(&insight).Updates(map[string]any{"status": insight.Status, "error": insight.Error})
+	}
+
+	return insight, nil
+}
+
+// CheckInsightRateLimit will return an error when the user created too many insight jobs recently.
+// The other requests to the ai service recorded by ConsumeInsightRateLimit are counted as well.
+func CheckInsightRateLimit(user uint) error {
+	since := time.Now().Add(-InsightRateWindow)
+
+	var insights, usages int64
+	if err := database.C.Unscoped().Model(&models.PostInsight{}).
+		Where("account_id = ? AND created_at > ?", user, since).
+		Count(&insights).Error; err != nil {
+		return err
+	}
+	if err := database.C.Model(&models.InsightUsage{}).
+		Where("account_id = ? AND created_at > ?", user, since).
+		Count(&usages).Error; err != nil {
+		return err
+	}
+	if insights+usages >= InsightRateLimit {
+		return ErrInsightRateLimited
 	}
+	return nil
+}
 
-	insight = models.PostInsight{
-		Response: resp.Response,
-		Post:     post,
-		PostID:   post.ID,
+// ConsumeInsightRateLimit will check the insight rate limit and record a usage of the kind.
+// Call it before the requests to the ai service which do not create a PostInsight.
+func ConsumeInsightRateLimit(user uint, kind string) error {
+	if err := CheckInsightRateLimit(user); err != nil {
+		return err
 	}
-	if err := database.C.Create(&insight).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to create post insight result in database...")
+	return database.C.Create(&models.InsightUsage{Kind: kind, AccountID: user}).Error
+}
+
+// EnqueuePostInsight will create a job to generate the insight of the post.
+// The existing insight of the current body is returned unless it failed or regenerate is true,
+// the running job is always returned to avoid generating the same post twice at once.
+func EnqueuePostInsight(post models.Post, user uint, regenerate bool) (models.PostInsight, error) {
+	if insight, err := GetPostInsight(post); err == nil {
+		switch {
+		case insight.Status == models.PostInsightPending:
+			return insight, nil
+		case insight.Status == models.PostInsightDone && !regenerate:
+			return insight, nil
+		}
 	}
 
-	return resp.Response, nil
+	if err := CheckInsightRateLimit(user); err != nil {
+		return models.PostInsight{}, err
+	}
+
+	insight := models.PostInsight{
+		Status:      models.PostInsightPending,
+		ContentHash: GetPostContentHash(post),
+		AccountID:   user,
+		PostID:      post.ID,
+	}
+	// The job created by another request at the same time is returned, so the quota is not consumed twice
+	result := database.C.Omit("Post").Clauses(clause.OnConflict{DoNothing: true}).Create(&insight)
+	if result.Error != nil {
+		return insight, result.Error
+	} else if result.RowsAffected == 0 {
+		var pending models.PostInsight
+		err := database.C.
+			Where("post_id = ? AND content_hash = ? AND status = ?", post.ID, insight.ContentHash, models.PostInsightPending).
+			First(&pending).Error
+		return pending, err
+	}
+
+	job := &insightJob{}
+	insightJobsLock.Lock()
+	insightJobs[insight.ID] = job
+	insightJobsLock.Unlock()
+
+	go runPostInsightJob(insight, job, getPostInsightSource(post))
+
+	return insight, nil
+}
+
+func runPostInsightJob(insight models.PostInsight, job *insightJob, source string) {
+	defer func() {
+		job.finish()
+		insightJobsLock.Lock()
+		delete(insightJobs, insight.ID)
+		insightJobsLock.Unlock()
+	}()
+
+	insightSlots <- struct{}{}
+	defer func() { <-insightSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), InsightJobTimeout)
+	defer cancel()
+
+	insight = generatePostInsight(ctx, DefaultInsightGenerator, insight, job, source)
+
+	if err := database.C.Model(&insight).Updates(map[string]any{
+		"status":   insight.Status,
+		"response": insight.Response,
+		"error":    insight.Error,
+	}).Error; err != nil {
+		log.Error().Err(err).Msg("Failed to update post insight result in database...")
+	}
+}
+
+// generatePostInsight will run the generator and fill the result into the insight,
+// the partial output is pushed to the subscribers of the job while generating.
+func generatePostInsight(ctx context.Context, generator InsightGenerator, insight models.PostInsight, job *insightJob, source string) models.PostInsight {
+	response, err := generator.Generate(ctx, source, insight.AccountID, job.push)
+	if err != nil {
+		log.Warn().Err(err).Uint("post", insight.PostID).Msg("Failed to generate post insight...")
+		insight.Status = models.PostInsightFailed
+		insight.Error = err.Error()
+	} else {
+		insight.Status = models.PostInsightDone
+		insight.Response = response
+	}
+	return insight
+}
+
+// InvalidatePostInsights will delete the insights that no longer match the post body.
+// The running jobs are kept and their results will be ignored since the hash is outdated.
+// They were soft deleted, so they are still counted in the rate limit.
+func InvalidatePostInsights(post models.Post) error {
+	return database.C.
+		Where("post_id = ? AND content_hash <> ? AND status <> ?", post.ID, GetPostContentHash(post), models.PostInsightPending).
+		Delete(&models.PostInsight{}).Error
 }
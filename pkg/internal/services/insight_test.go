@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type stubInsightGenerator struct {
+	chunks  []string
+	err     error
+	release chan struct{}
+}
+
+func (v *stubInsightGenerator) Generate(ctx context.Context, source string, user uint, onChunk func(string)) (string, error) {
+	if v.release != nil {
+		<-v.release
+	}
+	for _, chunk := range v.chunks {
+		onChunk(chunk)
+	}
+	if v.err != nil {
+		return "", v.err
+	}
+	return strings.Join(v.chunks, ""), nil
+}
+
+func registerTestInsightJob(t *testing.T, id uint) *insightJob {
+	t.Helper()
+	job := &insightJob{}
+	insightJobsLock.Lock()
+	insightJobs[id] = job
+	insightJobsLock.Unlock()
+	t.Cleanup(func() {
+		insightJobsLock.Lock()
+		delete(insightJobs, id)
+		insightJobsLock.Unlock()
+	})
+	return job
+}
+
+func TestGeneratePostInsightStreamsChunks(t *testing.T) {
+	generator := &stubInsightGenerator{chunks: []string{"Hello, ", "world"}, release: make(chan struct{})}
+	insight := models.PostInsight{Status: models.PostInsightPending, PostID: 1}
+	insight.ID = 1001
+	job := registerTestInsightJob(t, insight.ID)
+
+	partial, chunks, ok := SubscribePostInsight(insight.ID)
+	if !ok || partial != "" {
+		t.Fatalf("expected to subscribe the running job, got %q and %v", partial, ok)
+	}
+
+	done := make(chan models.PostInsight)
+	go func() {
+		result := generatePostInsight(context.Background(), generator, insight, job, "source")
+		job.finish()
+		done <- result
+	}()
+	close(generator.release)
+
+	var received strings.Builder
+	for chunk := range chunks {
+		received.WriteString(chunk)
+	}
+	result := <-done
+
+	if received.String() != "Hello, world" {
+		t.Errorf("expected the chunks to be streamed, got %q", received.String())
+	}
+	if result.Status != models.PostInsightDone || result.Response != "Hello, world" || result.Error != "" {
+		t.Errorf("expected the insight to be done, got %+v", result)
+	}
+
+	// The late subscribers get the whole partial output and a closed channel
+	partial, chunks, ok = SubscribePostInsight(insight.ID)
+	if !ok || partial != "Hello, world" {
+		t.Fatalf("expected the partial output, got %q and %v", partial, ok)
+	}
+	if _, open := <-chunks; open {
+		t.Error("expected the channel of a finished job to be closed")
+	}
+}
+
+func TestGeneratePostInsightFailed(t *testing.T) {
+	generator := &stubInsightGenerator{chunks: []string{"Hel"}, err: errors.New("model overloaded")}
+	insight := models.PostInsight{Status: models.PostInsightPending, PostID: 1}
+	insight.ID = 1002
+	job := registerTestInsightJob(t, insight.ID)
+
+	result := generatePostInsight(context.Background(), generator, insight, job, "source")
+	if result.Status != models.PostInsightFailed || result.Error != "model overloaded" || result.Response != "" {
+		t.Errorf("expected the insight to fail, got %+v", result)
+	}
+}
+
+func TestSubscribePostInsightNotRunning(t *testing.T) {
+	if _, _, ok := SubscribePostInsight(999999); ok {
+		t.Error("expected the job not running in this instance to be reported")
+	}
+}
+
+func TestPostContentHashInvalidation(t *testing.T) {
+	post := models.Post{Body: map[string]any{"title": "Title", "content": "Content"}}
+	hash := GetPostContentHash(post)
+
+	post.Body["content_html"] = "<p>Content</p>"
+	if GetPostContentHash(post) != hash {
+		t.Error("expected the fields not translated or summarized to be ignored")
+	}
+
+	post.Body["content"] = "Changed"
+	if GetPostContentHash(post) == hash {
+		t.Error("expected the hash to change with the content")
+	}
+
+	// The parts are separated, so moving text between the fields changes the hash
+	a := models.Post{Body: map[string]any{"title": "ab", "content": ""}}
+	b := models.Post{Body: map[string]any{"title": "a", "content": "b"}}
+	if GetPostContentHash(a) == GetPostContentHash(b) {
+		t.Error("expected different fields to produce different hashes")
+	}
+}
+
+// openTestDatabase will connect the database given by INTERACTIVE_TEST_DSN, the test is skipped without it.
+func openTestDatabase(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("INTERACTIVE_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("INTERACTIVE_TEST_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("unable to connect database: %v", err)
+	}
+	if err := database.RunMigration(db); err != nil {
+		t.Fatalf("unable to migrate database: %v", err)
+	}
+	previous := database.C
+	database.C = db
+	t.Cleanup(func() { database.C = previous })
+}
+
+func TestInsightRateLimit(t *testing.T) {
+	openTestDatabase(t)
+	user := uint(time.Now().UnixNano()%1_000_000) + 1_000_000_000
+	t.Cleanup(func() {
+		database.C.Unscoped().Where("account_id = ?", user).Delete(&models.InsightUsage{})
+	})
+
+	for idx := 0; idx < InsightRateLimit; idx++ {
+		if err := ConsumeInsightRateLimit(user, "test"); err != nil {
+			t.Fatalf("unexpected error at %d: %v", idx, err)
+		}
+	}
+	if err := ConsumeInsightRateLimit(user, "test"); !errors.Is(err, ErrInsightRateLimited) {
+		t.Fatalf("expected ErrInsightRateLimited, got %v", err)
+	}
+}
+
+func TestEnqueuePostInsightShared(t *testing.T) {
+	openTestDatabase(t)
+	user := uint(time.Now().UnixNano()%1_000_000) + 1_000_000_000
+
+	publisher := models.Publisher{Name: fmt.Sprintf("insight-test-%d", user), Nick: "Insight Test"}
+	if err := database.C.Create(&publisher).Error; err != nil {
+		t.Fatalf("unable to create publisher: %v", err)
+	}
+	post := models.Post{Type: models.PostTypeStory, Body: map[string]any{"content": "Hello"}, PublisherID: publisher.ID}
+	if err := database.C.Create(&post).Error; err != nil {
+		t.Fatalf("unable to create post: %v", err)
+	}
+	t.Cleanup(func() {
+		database.C.Unscoped().Where("post_id = ?", post.ID).Delete(&models.PostInsight{})
+		database.C.Unscoped().Delete(&post)
+		database.C.Unscoped().Delete(&publisher)
+	})
+
+	generator := &stubInsightGenerator{chunks: []string{"summary"}, release: make(chan struct{})}
+	release := sync.OnceFunc(func() { close(generator.release) })
+	previous := DefaultInsightGenerator
+	DefaultInsightGenerator = generator
+	t.Cleanup(func() {
+		release()
+		DefaultInsightGenerator = previous
+	})
+
+	results := make(chan models.PostInsight, 2)
+	for idx := 0; idx < 2; idx++ {
+		go func() {
+			insight, err := EnqueuePostInsight(post, user, false)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- insight
+		}()
+	}
+	first, second := <-results, <-results
+	if first.ID == 0 || first.ID != second.ID {
+		t.Fatalf("expected the concurrent requests to share the job, got %d and %d", first.ID, second.ID)
+	}
+
+	var count int64
+	database.C.Unscoped().Model(&models.PostInsight{}).Where("account_id = ?", user).Count(&count)
+	if count != 1 {
+		t.Fatalf("expected one job to be created, got %d", count)
+	}
+
+	release()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if insight, err := GetPostInsight(post); err == nil && insight.Status == models.PostInsightDone {
+			if insight.Response != "summary" {
+				t.Errorf("unexpected response %q", insight.Response)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("expected the job to be done")
+}
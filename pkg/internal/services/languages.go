@@ -1,13 +1,37 @@
 package services
 
 import (
+	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
 	"github.com/pemistahl/lingua-go"
 )
 
-var detector lingua.LanguageDetector
+const (
+	// LanguageUnknown is used when the language cannot be detected
+	LanguageUnknown = "unknown"
+	// LanguageConfidenceThreshold is the confidence below which the author's history was used instead
+	LanguageConfidenceThreshold = 0.5
+	// LanguageScoresMax is the count of the top languages stored with the post
+	LanguageScoresMax = 3
+	// LanguageMixedMinLength is the minimal length of the content to be detected as mixed languages
+	LanguageMixedMinLength = 64
+	// LanguageHistorySize is the count of the author's recent posts used to guess the language
+	LanguageHistorySize = 50
+)
+
+var (
+	detector     lingua.LanguageDetector
+	detectorOnce sync.Once
+)
 
+// CreateLanguageDetector will create the detector of all the languages in the low accuracy mode.
+// The high accuracy models of all the languages take about 1.8 GB of memory, the low accuracy mode only loads the trigram models,
+// which are far smaller. The short texts are detected less accurately, and those posts will fall back to the author's history.
 func CreateLanguageDetector() lingua.LanguageDetector {
 	return lingua.NewLanguageDetectorBuilder().
 		FromAllLanguages().
@@ -15,13 +39,158 @@ func CreateLanguageDetector() lingua.LanguageDetector {
 		Build()
 }
 
-func DetectLanguage(content string) string {
-	if detector == nil {
+func getLanguageDetector() lingua.LanguageDetector {
+	detectorOnce.Do(func() {
 		detector = CreateLanguageDetector()
+	})
+	return detector
+}
+
+func getLanguageCode(lang lingua.Language) string {
+	return strings.ToLower(lang.IsoCode639_1().String())
+}
+
+// NormalizeLanguageCode will check the language is a valid ISO 639-1 code and return it in lower case.
+func NormalizeLanguageCode(code string) (string, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == LanguageUnknown {
+		return code, nil
+	}
+	if lingua.GetLanguageFromIsoCode639_1(lingua.GetIsoCode639_1FromValue(code)) == lingua.Unknown {
+		return code, fmt.Errorf("invalid language code %s, must be an ISO 639-1 code", code)
+	}
+	return code, nil
+}
+
+// ParseLanguage will return the ISO 639-1 code of the language given by its code or its English name, such as english.
+// The names were stored by the older versions, the clients may still send them.
+func ParseLanguage(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, lang := range lingua.AllLanguages() {
+		if strings.ToLower(lang.String()) == value {
+			return getLanguageCode(lang), nil
+		}
+	}
+	return NormalizeLanguageCode(value)
+}
+
+// DetectLanguageScores will return the most possible languages of the content with their confidence.
+// When the content is long but no language is confident enough, it is treated as mixed languages,
+// and the confidence is the proportion of the content in that language.
+func DetectLanguageScores(content string) []models.LanguageScore {
+	content = strings.TrimSpace(content)
+	if len(content) == 0 {
+		return nil
+	}
+
+	var scores []models.LanguageScore
+	for _, value := range getLanguageDetector().ComputeLanguageConfidenceValues(content) {
+		if value.Value() <= 0 || len(scores) >= LanguageScoresMax {
+			break
+		}
+		scores = append(scores, models.LanguageScore{
+			Language:   getLanguageCode(value.Language()),
+			Confidence: value.Value(),
+		})
+	}
+
+	if len(scores) > 0 && scores[0].Confidence >= LanguageConfidenceThreshold {
+		return scores
+	} else if len([]rune(content)) < LanguageMixedMinLength {
+		return scores
+	}
+
+	sections := getLanguageDetector().DetectMultipleLanguagesOf(content)
+	if len(sections) < 2 {
+		return scores
 	}
 
-	if lang, ok := detector.DetectLanguageOf(content); ok {
-		return strings.ToLower(lang.String())
+	total := 0
+	proportions := make(map[lingua.Language]int)
+	for _, section := range sections {
+		length := section.EndIndex() - section.StartIndex()
+		proportions[section.Language()] += length
+		total += length
 	}
-	return "unknown"
+
+	mixed := make([]models.LanguageScore, 0, len(proportions))
+	for lang, length := range proportions {
+		if lang == lingua.Unknown || total == 0 {
+			continue
+		}
+		mixed = append(mixed, models.LanguageScore{
+			Language:   getLanguageCode(lang),
+			Confidence: float64(length) / float64(total),
+		})
+	}
+	sort.Slice(mixed, func(i, j int) bool {
+		return mixed[i].Confidence > mixed[j].Confidence
+	})
+	if len(mixed) > LanguageScoresMax {
+		mixed = mixed[:LanguageScoresMax]
+	}
+
+	return mixed
+}
+
+// DetectLanguage will return the ISO 639-1 code of the most possible language of the content.
+func DetectLanguage(content string) string {
+	if scores := DetectLanguageScores(content); len(scores) > 0 {
+		return scores[0].Language
+	}
+	return LanguageUnknown
+}
+
+// GetPublisherLanguage will return the language most used in the recent posts of the publisher.
+func GetPublisherLanguage(publisherId uint) string {
+	var language string
+	database.C.
+		Table("(?) AS recent", database.C.Model(&models.Post{}).
+			Select("language").
+			Where("publisher_id = ?", publisherId).
+			Order("created_at DESC").
+			Limit(LanguageHistorySize),
+		).
+		Select("language").
+		Where("language NOT IN ?", []string{"", LanguageUnknown}).
+		Group("language").
+		Order("COUNT(*) DESC").
+		Limit(1).
+		Scan(&language)
+	return language
+}
+
+func getPostLanguageSource(item models.Post) string {
+	var parts []string
+	for _, key := range []string{"title", "description", "content"} {
+		if val, ok := item.Body[key].(string); ok && len(val) > 0 {
+			parts = append(parts, val)
+		}
+	}
+	return RenderMarkdownPlainText(strings.Join(parts, "\n\n"))
+}
+
+// ResolvePostLanguage will fill the language and its scores of the post.
+// The language overridden by the author is kept, otherwise it will be detected from the post body,
+// and the author's history will be used when the detection is not confident enough.
+func ResolvePostLanguage(item models.Post) (models.Post, error) {
+	item.LanguageScores = DetectLanguageScores(getPostLanguageSource(item))
+
+	if item.IsLanguageOverridden && len(item.Language) > 0 {
+		var err error
+		item.Language, err = NormalizeLanguageCode(item.Language)
+		return item, err
+	}
+
+	item.Language = LanguageUnknown
+	if len(item.LanguageScores) > 0 {
+		item.Language = item.LanguageScores[0].Language
+	}
+	if len(item.LanguageScores) == 0 || item.LanguageScores[0].Confidence < LanguageConfidenceThreshold {
+		if history := GetPublisherLanguage(item.PublisherID); len(history) > 0 {
+			item.Language = history
+		}
+	}
+
+	return item, nil
 }
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"golang.org/x/net/html"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LinkPreviewMaxPerPost   = 5
+	LinkPreviewMaxBodySize  = 1 << 20
+	LinkPreviewMaxRedirects = 3
+	LinkPreviewTimeout      = 8 * time.Second
+	LinkPreviewLifetime     = 24 * time.Hour
+
+	LinkPreviewFailureLifetime = time.Hour
+)
+
+var linkRegex = regexp.MustCompile(`https?://[^\s<>()\[\]{}"'` + "`" + `]+`)
+
+var carrierGradeNat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		carrierGradeNat.Contains(ip))
+}
+
+// LinkUnfurler fetches the metadata of the links.
+type LinkUnfurler struct {
+	Client      *http.Client
+	UserAgent   string
+	MaxBodySize int64
+}
+
+// NewLinkUnfurler will create an unfurler with strict timeouts and size limits.
+// The address was checked after the DNS resolving, so the private network cannot be reached by the rebinding.
+// Set allowPrivate to true only when unfurling the links served in local, like a stub server.
+func NewLinkUnfurler(allowPrivate bool) *LinkUnfurler {
+	dialer := &net.Dialer{
+		Timeout: 3 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if allowPrivate {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("address %s is not allowed", address)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 5 * time.Second,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &LinkUnfurler{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   LinkPreviewTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= LinkPreviewMaxRedirects {
+					return fmt.Errorf("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to %s is not allowed", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		UserAgent:   "SolarNetwork-Interactive/1.0 (+link preview)",
+		MaxBodySize: LinkPreviewMaxBodySize,
+	}
+}
+
+// DefaultLinkUnfurler is used when creating and editing posts, replace it to unfurl against another network.
+var DefaultLinkUnfurler = NewLinkUnfurler(false)
+
+func (v *LinkUnfurler) fetch(ctx context.Context, target string, accept string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", v.UserAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if resp.ContentLength > v.MaxBodySize {
+		return resp, nil, fmt.Errorf("response is too large")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, v.MaxBodySize))
+	return resp, body, err
+}
+
+// Unfurl will fetch the OpenGraph, Twitter Card and oEmbed metadata of the link.
+func (v *LinkUnfurler) Unfurl(ctx context.Context, target string) (models.LinkPreview, error) {
+	preview := models.LinkPreview{URL: target, FetchedAt: time.Now()}
+
+	if uri, err := url.Parse(target); err != nil || (uri.Scheme != "http" && uri.Scheme != "https") {
+		return preview, fmt.Errorf("invalid link: %s", target)
+	}
+
+	resp, body, err := v.fetch(ctx, target, "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8")
+	if err != nil {
+		return preview, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		preview.Type = "image"
+		preview.Image = target
+		return preview, nil
+	case mediaType != "text/html" && mediaType != "application/xhtml+xml":
+		return preview, fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	meta, oembed := parseLinkMetadata(body)
+	base := resp.Request.URL
+	resolve := func(ref string) string {
+		if len(ref) == 0 {
+			return ""
+		}
+		if uri, err := base.Parse(ref); err == nil && (uri.Scheme == "http" || uri.Scheme == "https") {
+			return uri.String()
+		}
+		return ""
+	}
+
+	preview.Type = lo.CoalesceOrEmpty(meta["og:type"], "website")
+	preview.Title = lo.CoalesceOrEmpty(meta["og:title"], meta["twitter:title"], meta["title"])
+	preview.Description = lo.CoalesceOrEmpty(meta["og:description"], meta["twitter:description"], meta["description"])
+	preview.Image = resolve(lo.CoalesceOrEmpty(meta["og:image"], meta["twitter:image"], meta["twitter:image:src"]))
+	preview.SiteName = lo.CoalesceOrEmpty(meta["og:site_name"], base.Hostname())
+	preview.Author = lo.CoalesceOrEmpty(meta["author"], meta["twitter:creator"])
+	preview.Icon = resolve(lo.CoalesceOrEmpty(meta["icon"], "/favicon.ico"))
+
+	if endpoint := resolve(oembed); len(endpoint) > 0 {
+		if _, body, err := v.fetch(ctx, endpoint, "application/json"); err == nil {
+			var data struct {
+				Type         string `json:"type"`
+				Title        string `json:"title"`
+				AuthorName   string `json:"author_name"`
+				ProviderName string `json:"provider_name"`
+				ThumbnailURL string `json:"thumbnail_url"`
+			}
+			if err := json.Unmarshal(body, &data); err == nil {
+				preview.Title = lo.CoalesceOrEmpty(preview.Title, data.Title)
+				preview.Author = lo.CoalesceOrEmpty(preview.Author, data.AuthorName)
+				preview.SiteName = lo.CoalesceOrEmpty(data.ProviderName, preview.SiteName)
+				preview.Image = lo.CoalesceOrEmpty(preview.Image, resolve(data.ThumbnailURL))
+				if data.Type == "video" || data.Type == "photo" {
+					preview.Type = data.Type
+				}
+			}
+		} else {
+			log.Debug().Err(err).Str("endpoint", endpoint).Msg("Unable to fetch oEmbed metadata...")
+		}
+	}
+
+	preview.Title = TruncateLinkPreviewText(preview.Title, 256)
+	preview.Description = TruncateLinkPreviewText(preview.Description, 1024)
+
+	return preview, nil
+}
+
+// parseLinkMetadata will read the meta tags in the head of the page.
+// The tag name was used as the key, the title element was stored as title and the icon link was stored as icon.
+func parseLinkMetadata(body []byte) (map[string]string, string) {
+	meta := make(map[string]string)
+	var oembed string
+
+	tokenizer := html.NewTokenizer(strings.NewReader(string(body)))
+	inTitle := false
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return meta, oembed
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "head" {
+				return meta, oembed
+			} else if string(name) == "title" {
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				if _, ok := meta["title"]; !ok {
+					meta["title"] = strings.TrimSpace(string(tokenizer.Text()))
+				}
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = tokenizer.TagAttr()
+				attrs[strings.ToLower(string(key))] = string(val)
+			}
+
+			switch string(name) {
+			case "body":
+				return meta, oembed
+			case "title":
+				inTitle = true
+			case "meta":
+				key := strings.ToLower(lo.CoalesceOrEmpty(attrs["property"], attrs["name"]))
+				if _, ok := meta[key]; !ok && len(key) > 0 && len(attrs["content"]) > 0 {
+					meta[key] = strings.TrimSpace(attrs["content"])
+				}
+			case "link":
+				rel := strings.ToLower(attrs["rel"])
+				switch {
+				case rel == "alternate" && attrs["type"] == "application/json+oembed":
+					oembed = attrs["href"]
+				case lo.Contains(strings.Fields(rel), "icon"):
+					if _, ok := meta["icon"]; !ok {
+						meta["icon"] = attrs["href"]
+					}
+				}
+			}
+		}
+	}
+}
+
+func TruncateLinkPreviewText(content string, length int) string {
+	if len([]rune(content)) > length {
+		return string([]rune(content)[:length]) + "..."
+	}
+	return content
+}
+
+// ExtractLinks will find the http and https links in the content, the duplicated links were removed.
+func ExtractLinks(content string) []string {
+	var out []string
+	for _, link := range linkRegex.FindAllString(content, -1) {
+		link = strings.TrimRight(link, ".,;:!?*_~")
+		if len(link) > 2048 {
+			continue
+		}
+		if !lo.Contains(out, link) {
+			out = append(out, link)
+		}
+		if len(out) >= LinkPreviewMaxPerPost {
+			break
+		}
+	}
+	return out
+}
+
+// GetLinkPreview will return the cached preview of the link, or unfurl it when the cache is missing or expired.
+// When the unfurling failed, the expired preview is served as is, and the failure is cached for LinkPreviewFailureLifetime,
+// so the dead links will not be fetched on every edit.
+func GetLinkPreview(ctx context.Context, link string) (models.LinkPreview, error) {
+	var cached models.LinkPreview
+	found := database.C.Where("url = ?", link).First(&cached).Error == nil
+	hasData := found && !cached.FetchedAt.IsZero()
+
+	if hasData && time.Since(cached.FetchedAt) < LinkPreviewLifetime {
+		return cached, nil
+	}
+	if found && cached.FailedAt != nil && time.Since(*cached.FailedAt) < LinkPreviewFailureLifetime {
+		if hasData {
+			return cached, nil
+		}
+		return cached, fmt.Errorf("link failed to unfurl recently: %s", cached.Error)
+	}
+
+	fetched, err := DefaultLinkUnfurler.Unfurl(ctx, link)
+	if err != nil {
+		failure := models.LinkPreview{URL: link, Error: err.Error(), FailedAt: lo.ToPtr(time.Now())}
+		if err := database.C.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "url"}},
+			DoUpdates: clause.AssignmentColumns([]string{"error", "failed_at", "updated_at"}),
+		}).Create(&failure).Error; err != nil {
+			log.Warn().Err(err).Str("link", link).Msg("Unable to cache link preview failure...")
+		}
+		if hasData {
+			return cached, nil
+		}
+		return cached, err
+	}
+
+	if err := database.C.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "url"}},
+		DoUpdates: clause.AssignmentColumns([]string{"type", "title", "description", "image", "icon", "site_name", "author", "fetched_at", "error", "failed_at", "updated_at"}),
+	}).Create(&fetched).Error; err != nil {
+		return fetched, err
+	}
+	if err := database.C.Where("url = ?", link).First(&fetched).Error; err != nil {
+		return fetched, err
+	}
+
+	return fetched, nil
+}
+
+// UpdatePostLinkPreviews will unfurl the links in the content of the post and attach them to the post.
+// It makes network requests, call it in another goroutine.
+func UpdatePostLinkPreviews(item models.Post) {
+	content, _ := item.Body["content"].(string)
+	links := ExtractLinks(content)
+
+	ctx, cancel := context.WithTimeout(context.Background(), LinkPreviewTimeout*LinkPreviewMaxPerPost)
+	defer cancel()
+
+	previews := make([]models.LinkPreview, 0, len(links))
+	for _, link := range links {
+		preview, err := GetLinkPreview(ctx, link)
+		if err != nil {
+			log.Debug().Err(err).Str("link", link).Msg("Unable to unfurl link...")
+			continue
+		}
+		previews = append(previews, preview)
+	}
+
+	if err := database.C.Model(&models.Post{BaseModel: item.BaseModel}).
+		Association("LinkPreviews").
+		Replace(previews); err != nil {
+		log.Error().Err(err).Uint("post", item.ID).Msg("An error occurred when updating post link previews...")
+	}
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newLinkStubServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><head>
+<title>Fallback Title</title>
+<meta property="og:title" content="Stub Article">
+<meta property="og:description" content="An article served by the stub.">
+<meta property="og:image" content="/cover.png">
+<meta property="og:site_name" content="Stub Site">
+<meta name="author" content="Stub Author">
+<link rel="icon" href="/icon.png">
+<link rel="alternate" type="application/json+oembed" href="/oembed">
+</head><body><meta property="og:title" content="Ignored"></body></html>`)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"type":"video","title":"oEmbed Title","provider_name":"Stub Video"}`)
+	})
+	mux.HandleFunc("/twitter", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><meta name="twitter:title" content="Card Title"><meta name="twitter:image" content="https://example.com/card.png"></head></html>`)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+	mux.HandleFunc("/binary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte{0, 1, 2})
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", fmt.Sprint(LinkPreviewMaxBodySize+1))
+		w.Write([]byte(strings.Repeat("a", LinkPreviewMaxBodySize+1)))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestUnfurlOpenGraphAndOEmbed(t *testing.T) {
+	server := newLinkStubServer()
+	defer server.Close()
+
+	preview, err := NewLinkUnfurler(true).Unfurl(context.Background(), server.URL+"/article")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if preview.Title != "Stub Article" {
+		t.Errorf("expected the og title, got %q", preview.Title)
+	}
+	if preview.Description != "An article served by the stub." {
+		t.Errorf("unexpected description %q", preview.Description)
+	}
+	if preview.Image != server.URL+"/cover.png" {
+		t.Errorf("expected the image resolved against the page, got %q", preview.Image)
+	}
+	if preview.Icon != server.URL+"/icon.png" {
+		t.Errorf("unexpected icon %q", preview.Icon)
+	}
+	if preview.Author != "Stub Author" {
+		t.Errorf("unexpected author %q", preview.Author)
+	}
+	if preview.Type != "video" || preview.SiteName != "Stub Video" {
+		t.Errorf("expected the oEmbed type and provider, got %q and %q", preview.Type, preview.SiteName)
+	}
+}
+
+func TestUnfurlTwitterCard(t *testing.T) {
+	server := newLinkStubServer()
+	defer server.Close()
+
+	preview, err := NewLinkUnfurler(true).Unfurl(context.Background(), server.URL+"/twitter")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if preview.Title != "Card Title" || preview.Image != "https://example.com/card.png" {
+		t.Errorf("expected the twitter card metadata, got %+v", preview)
+	}
+	if preview.Type != "website" {
+		t.Errorf("expected the default type, got %q", preview.Type)
+	}
+}
+
+func TestUnfurlContentTypes(t *testing.T) {
+	server := newLinkStubServer()
+	defer server.Close()
+	unfurler := NewLinkUnfurler(true)
+
+	preview, err := unfurler.Unfurl(context.Background(), server.URL+"/image")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if preview.Type != "image" || preview.Image != server.URL+"/image" {
+		t.Errorf("expected an image preview, got %+v", preview)
+	}
+
+	if _, err := unfurler.Unfurl(context.Background(), server.URL+"/binary"); err == nil {
+		t.Error("expected an error for unsupported content type")
+	}
+}
+
+func TestUnfurlLimits(t *testing.T) {
+	server := newLinkStubServer()
+	defer server.Close()
+	unfurler := NewLinkUnfurler(true)
+
+	for _, path := range []string{"/huge", "/missing", "/loop"} {
+		if _, err := unfurler.Unfurl(context.Background(), server.URL+path); err == nil {
+			t.Errorf("expected an error for %s", path)
+		}
+	}
+	if _, err := unfurler.Unfurl(context.Background(), "ftp://example.com/file"); err == nil {
+		t.Error("expected an error for non-http link")
+	}
+}
+
+func TestUnfurlRejectsPrivateAddress(t *testing.T) {
+	server := newLinkStubServer()
+	defer server.Close()
+
+	if _, err := NewLinkUnfurler(false).Unfurl(context.Background(), server.URL+"/article"); err == nil {
+		t.Fatal("expected the loopback address to be rejected")
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	links := ExtractLinks("See https://example.com/a, and (https://example.com/b). Again https://example.com/a!")
+	if len(links) != 2 || links[0] != "https://example.com/a" || links[1] != "https://example.com/b" {
+		t.Fatalf("unexpected links %v", links)
+	}
+
+	var content []string
+	for i := 0; i < LinkPreviewMaxPerPost+3; i++ {
+		content = append(content, fmt.Sprintf("https://example.com/%d", i))
+	}
+	if links := ExtractLinks(strings.Join(content, " ")); len(links) != LinkPreviewMaxPerPost {
+		t.Fatalf("expected %d links, got %d", LinkPreviewMaxPerPost, len(links))
+	}
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// The math expressions were rendered as the escaped TeX source wrapped with the math class,
+// and leave the typesetting to the client, like KaTeX or MathJax.
+
+var kindMathInline = ast.NewNodeKind("MathInline")
+var kindMathBlock = ast.NewNodeKind("MathBlock")
+
+type mathInline struct {
+	ast.BaseInline
+	Display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+type mathBlock struct {
+	ast.BaseBlock
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) { ast.DumpHelper(n, source, level, nil, nil) }
+
+type mathInlineParser struct{}
+
+func (s *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (s *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, startSegment := block.PeekLine()
+	opener := 0
+	for ; opener < len(line) && line[opener] == '$'; opener++ {
+	}
+	if opener > 2 || opener >= len(line) || util.IsSpace(line[opener]) {
+		return nil
+	}
+
+	// Look for the closer in the same line, the price like $5 and $10 will not be treated as math
+	for i := opener; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] != '$' {
+			continue
+		}
+		closer := 0
+		for ; i+closer < len(line) && line[i+closer] == '$'; closer++ {
+		}
+		if closer != opener || util.IsSpace(line[i-1]) {
+			i += closer - 1
+			continue
+		}
+		if opener == 1 && i+closer < len(line) && line[i+closer] >= '0' && line[i+closer] <= '9' {
+			i += closer - 1
+			continue
+		}
+
+		node := &mathInline{Display: opener == 2}
+		segment := text.NewSegment(startSegment.Start+opener, startSegment.Start+i)
+		node.AppendChild(node, ast.NewRawTextSegment(segment))
+		block.Advance(i + closer)
+		return node
+	}
+
+	return nil
+}
+
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	if string(util.TrimRightSpace(util.TrimLeftSpace(line))) != "$$" {
+		return nil, parser.NoChildren
+	}
+	reader.AdvanceToEOL()
+	return &mathBlock{}, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if string(util.TrimRightSpace(util.TrimLeftSpace(line))) == "$$" {
+		reader.AdvanceToEOL()
+		return parser.Close
+	}
+	node.Lines().Append(segment)
+	reader.AdvanceToEOL()
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+type mathRenderer struct{}
+
+func (r *mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMathInline, r.renderMathInline)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathRenderer) renderMathInline(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	if n.(*mathInline).Display {
+		_, _ = w.WriteString(`<span class="math math-display">`)
+	} else {
+		_, _ = w.WriteString(`<span class="math math-inline">`)
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t, ok := c.(*ast.Text); ok {
+			_, _ = w.Write(util.EscapeHTML(t.Segment.Value(source)))
+		}
+	}
+	_, _ = w.WriteString(`</span>`)
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathRenderer) renderMathBlock(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	_, _ = w.WriteString(`<div class="math math-display">`)
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+	}
+	_, _ = w.WriteString("</div>\n")
+	return ast.WalkContinue, nil
+}
+
+type mathExtension struct{}
+
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(&mathBlockParser{}, 700)),
+		parser.WithInlineParsers(util.Prioritized(&mathInlineParser{}, 500)),
+	)
+	m.Renderer().AddOptions(
+		renderer.WithNodeRenderers(util.Prioritized(&mathRenderer{}, 500)),
+	)
+}
+
+// markdownHeadingIDs generates the heading anchors, the letters in any language were kept,
+// so the headings written in CJK will not all become the same anchor.
+type markdownHeadingIDs struct {
+	values map[string]bool
+}
+
+func newMarkdownParseContext() parser.Context {
+	return parser.NewContext(parser.WithIDs(&markdownHeadingIDs{values: make(map[string]bool)}))
+}
+
+func (s *markdownHeadingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var result []rune
+	for _, r := range strings.TrimSpace(string(value)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			result = append(result, unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			result = append(result, '-')
+		}
+	}
+
+	id := string(result)
+	if len(id) == 0 {
+		id = "heading"
+	}
+	if !s.values[id] {
+		s.values[id] = true
+		return []byte(id)
+	}
+	for i := 1; ; i++ {
+		if candidate := fmt.Sprintf("%s-%d", id, i); !s.values[candidate] {
+			s.values[candidate] = true
+			return []byte(candidate)
+		}
+	}
+}
+
+func (s *markdownHeadingIDs) Put(value []byte) {
+	s.values[string(value)] = true
+}
+
+var markdownEngine = goldmark.New(
+	goldmark.WithExtensions(
+		extension.Linkify,
+		extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+		extension.Strikethrough,
+		extension.TaskList,
+		extension.Footnote,
+		&mathExtension{},
+	),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(math|math-inline|math-display|language-[\w+#-]+|footnote-ref|footnote-backref|footnotes)( [\w-]+)*$`)).Globally()
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^fn(ref)?:[\w-]+$`)).OnElements("sup", "li")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|backlink|endnotes)$`)).OnElements("a", "div")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// RenderMarkdown will render the markdown content into sanitized html.
+// The raw html in the content will be dropped, and the output is safe to embed into the web page.
+func RenderMarkdown(content string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdownEngine.Convert([]byte(content), &buf, parser.WithContext(newMarkdownParseContext())); err != nil {
+		return "", err
+	}
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
+
+var plainTextBlankLines = regexp.MustCompile(`\n{3,}`)
+
+// RenderMarkdownPlainText will strip the markdown syntax from the content.
+// It is used for the previews and notifications, which cannot render the markdown.
+func RenderMarkdownPlainText(content string) string {
+	source := []byte(content)
+	document := markdownEngine.Parser().Parse(text.NewReader(source), parser.WithContext(newMarkdownParseContext()))
+
+	var buf strings.Builder
+	_ = ast.Walk(document, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			switch n.Kind() {
+			case east.KindTableCell:
+				buf.WriteString("\t")
+			case east.KindTableRow, east.KindTableHeader:
+				buf.WriteString("\n")
+			default:
+				if n.Type() == ast.TypeBlock && n.Kind() != ast.KindList && n.Kind() != ast.KindListItem && n.Kind() != ast.KindDocument {
+					buf.WriteString("\n")
+				}
+			}
+			return ast.WalkContinue, nil
+		}
+
+		switch node := n.(type) {
+		case *ast.Text:
+			buf.Write(node.Value(source))
+			if node.HardLineBreak() {
+				buf.WriteString("\n")
+			} else if node.SoftLineBreak() {
+				buf.WriteString(" ")
+			}
+		case *ast.String:
+			buf.Write(node.Value)
+		case *ast.AutoLink:
+			buf.Write(node.Label(source))
+		case *ast.RawHTML, *ast.HTMLBlock, *east.FootnoteLink, *east.FootnoteBacklink:
+			return ast.WalkSkipChildren, nil
+		case *ast.FencedCodeBlock, *ast.CodeBlock, *mathBlock:
+			lines := n.Lines()
+			for i := 0; i < lines.Len(); i++ {
+				line := lines.At(i)
+				buf.Write(line.Value(source))
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	out := strings.ReplaceAll(buf.String(), "\t\n", "\n")
+	out = plainTextBlankLines.ReplaceAllString(out, "\n\n")
+	return strings.TrimSpace(out)
+}
+
+// ListMarkdownHeading will return the headings of the content as the table of contents.
+// The anchor is the same as the id of the heading in the html rendered by RenderMarkdown.
+func ListMarkdownHeading(content string) []models.PostTableOfContentEntry {
+	source := []byte(content)
+	document := markdownEngine.Parser().Parse(text.NewReader(source), parser.WithContext(newMarkdownParseContext()))
+
+	out := make([]models.PostTableOfContentEntry, 0)
+	for n := document.FirstChild(); n != nil; n = n.NextSibling() {
+		heading, ok := n.(*ast.Heading)
+		if !ok {
+			continue
+		}
+		entry := models.PostTableOfContentEntry{Level: heading.Level}
+		if id, ok := heading.AttributeString("id"); ok {
+			if val, ok := id.([]byte); ok {
+				entry.Anchor = string(val)
+			}
+		}
+		var title strings.Builder
+		_ = ast.Walk(heading, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			switch node := n.(type) {
+			case *ast.Text:
+				title.Write(node.Value(source))
+			case *ast.String:
+				title.Write(node.Value)
+			}
+			return ast.WalkContinue, nil
+		})
+		entry.Title = title.String()
+		out = append(out, entry)
+	}
+
+	return out
+}
+
+// RenderPostContent will put the rendered html of the content into the post body as the content_html.
+// The reply and repost will be rendered too, the post body was changed in place.
+// The truncated posts were skipped, since their content no longer has the formatting.
+func RenderPostContent(post *models.Post) error {
+	if post == nil {
+		return nil
+	}
+
+	truncated, _ := post.Body["content_truncated"].(bool)
+	if val, ok := post.Body["content"].(string); ok && !truncated {
+		html, err := RenderMarkdown(val)
+		if err != nil {
+			return err
+		}
+		post.Body["content_html"] = html
+	}
+
+	if err := RenderPostContent(post.ReplyTo); err != nil {
+		return err
+	}
+	return RenderPostContent(post.RepostTo)
+}
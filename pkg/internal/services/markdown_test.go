@@ -0,0 +1,126 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+)
+
+func renderMarkdownForTest(t *testing.T, content string) string {
+	t.Helper()
+	out, err := RenderMarkdown(content)
+	if err != nil {
+		t.Fatalf("unexpected error rendering %q: %v", content, err)
+	}
+	return out
+}
+
+func TestRenderMarkdownSanitize(t *testing.T) {
+	for _, content := range []string{
+		"<script>alert(1)</script>hello",
+		"[click](javascript:alert(1))",
+		"<a href=\"javascript:alert(1)\">click</a>",
+		"<a href=\"https://example.com\" onclick=\"alert(1)\">x</a>",
+		"<img src=x onerror=alert(1)>",
+		"<div onmouseover=\"alert(1)\">hover</div>",
+		"<iframe src=\"https://example.com\"></iframe>",
+	} {
+		out := strings.ToLower(renderMarkdownForTest(t, content))
+		for _, unsafe := range []string{"<script", "javascript:", "onclick", "onerror", "onmouseover", "<iframe", "<img"} {
+			if strings.Contains(out, unsafe) {
+				t.Errorf("expected %q to be stripped from %q, got %q", unsafe, content, out)
+			}
+		}
+	}
+}
+
+func TestRenderMarkdownRawTextIsEscaped(t *testing.T) {
+	for content, expected := range map[string]string{
+		"$<script>alert(1)</script>$":             `<span class="math math-inline">&lt;script&gt;alert(1)&lt;/script&gt;</span>`,
+		"$$\n<img src=x onerror=alert(1)>\n$$":    `<div class="math math-display">&lt;img src=x onerror=alert(1)&gt;`,
+		"`<b>bold</b>`":                           `<code>&lt;b&gt;bold&lt;/b&gt;</code>`,
+		"```html\n<script>alert(1)</script>\n```": `<code class="language-html">&lt;script&gt;alert(1)&lt;/script&gt;`,
+	} {
+		out := renderMarkdownForTest(t, content)
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q to contain %q, got %q", content, expected, out)
+		}
+		if strings.Contains(out, "<script") || strings.Contains(out, "<img") || strings.Contains(out, "<b>") {
+			t.Errorf("expected the raw html in %q not to be passed through, got %q", content, out)
+		}
+	}
+}
+
+func TestRenderMarkdownMath(t *testing.T) {
+	for content, expected := range map[string]string{
+		"inline $x^2$ here":        `<p>inline <span class="math math-inline">x^2</span> here</p>`,
+		"display $$y$$ here":       `<p>display <span class="math math-display">y</span> here</p>`,
+		"$$\nx^2\n$$":              `<div class="math math-display">x^2`,
+		"$$\nx^2\n":                `<div class="math math-display">x^2`,
+		"price $5 and $6 today":    `<p>price $5 and $6 today</p>`,
+		"an $unterminated formula": `<p>an $unterminated formula</p>`,
+		"a $$half open":            `<p>a $$half open</p>`,
+		"escaped \\$x$ here":       `<p>escaped $x$ here</p>`,
+		"spaced $ x $ here":        `<p>spaced $ x $ here</p>`,
+	} {
+		if out := renderMarkdownForTest(t, content); !strings.Contains(out, expected) {
+			t.Errorf("expected %q to contain %q, got %q", content, expected, out)
+		}
+	}
+}
+
+func TestRenderMarkdownHeadingIDs(t *testing.T) {
+	out := renderMarkdownForTest(t, "# 你好 世界\n\n# 你好 世界\n\n## Hello\n\n## Hello\n\n## 日本語の見出し\n\n## !!!")
+	for _, expected := range []string{
+		`<h1 id="你好-世界">`,
+		`<h1 id="你好-世界-1">`,
+		`<h2 id="hello">`,
+		`<h2 id="hello-1">`,
+		`<h2 id="日本語の見出し">`,
+		`<h2 id="heading">`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in %q", expected, out)
+		}
+	}
+}
+
+func TestListMarkdownHeading(t *testing.T) {
+	headings := ListMarkdownHeading("# 你好 世界\n\ntext\n\n## Hello **World**\n\n## Hello World")
+	expected := []models.PostTableOfContentEntry{
+		{Level: 1, Title: "你好 世界", Anchor: "你好-世界"},
+		{Level: 2, Title: "Hello World", Anchor: "hello-world"},
+		{Level: 2, Title: "Hello World", Anchor: "hello-world-1"},
+	}
+	if len(headings) != len(expected) {
+		t.Fatalf("expected %d headings, got %+v", len(expected), headings)
+	}
+	for idx := range expected {
+		if headings[idx] != expected[idx] {
+			t.Errorf("expected %+v, got %+v", expected[idx], headings[idx])
+		}
+	}
+}
+
+func TestRenderMarkdownPlainText(t *testing.T) {
+	out := RenderMarkdownPlainText("# Title\n\nSome **bold** and <b>html</b> $x$\n\n```\ncode\n```")
+	if out != "Title\nSome bold and html x\ncode" {
+		t.Errorf("unexpected plain text %q", out)
+	}
+}
+
+func TestRenderPostContentSkipsTruncated(t *testing.T) {
+	post := &models.Post{Body: map[string]any{"content": "**bold**"}}
+	post.ReplyTo = &models.Post{Body: map[string]any{"content": "cut", "content_truncated": true}}
+
+	if err := RenderPostContent(post); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if html, _ := post.Body["content_html"].(string); !strings.Contains(html, "<strong>bold</strong>") {
+		t.Errorf("expected the content to be rendered, got %q", html)
+	}
+	if _, ok := post.ReplyTo.Body["content_html"]; ok {
+		t.Error("expected the truncated reply not to be rendered")
+	}
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/passport/pkg/authkit"
+	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OutboxBatchSize   = 50
+	OutboxMaxAttempts = 8
+	OutboxBaseBackoff = 5 * time.Second
+	OutboxMaxBackoff  = time.Hour
+	// OutboxLeaseDuration is how long a claimed entry is hidden from the other instances
+	OutboxLeaseDuration = 2 * time.Minute
+)
+
+// Notifier is the destination of the notification outbox.
+// Replace OutboxNotifier with a fake one to drain the outbox without Pusher.
+type Notifier interface {
+	NotifyUserBatch(userIDs []uint64, notification pushkit.Notification) error
+}
+
+type nexusNotifier struct{}
+
+func (nexusNotifier) NotifyUserBatch(userIDs []uint64, notification pushkit.Notification) error {
+	return authkit.NotifyUserBatch(gap.Nx, userIDs, notification)
+}
+
+var OutboxNotifier Notifier = nexusNotifier{}
+
+type OutboxMetrics struct {
+	Sent         int64 `json:"sent"`
+	Failed       int64 `json:"failed"`
+	DeadLettered int64 `json:"dead_lettered"`
+	Pending      int64 `json:"pending"`
+	Dead         int64 `json:"dead"`
+}
+
+var (
+	outboxSent         atomic.Int64
+	outboxFailed       atomic.Int64
+	outboxDeadLettered atomic.Int64
+	outboxDrainLock    sync.Mutex
+)
+
+// PendingNotification is a notification which is ready to be put into the outbox.
+type PendingNotification struct {
+	UserIDs      []uint64
+	Notification pushkit.Notification
+}
+
+func EnqueueNotification(tx *gorm.DB, userIDs []uint64, notification pushkit.Notification) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	entry := models.NotificationOutbox{
+		Kind:          models.OutboxKindNotification,
+		UserIDs:       userIDs,
+		Notification:  datatypes.NewJSONType(notification),
+		NextAttemptAt: time.Now(),
+	}
+	return tx.Create(&entry).Error
+}
+
+// EnqueuePostSubscription will put a post into the outbox, the worker will fan out it to the subscribers once the post is published.
+func EnqueuePostSubscription(tx *gorm.DB, item models.Post) error {
+	entry := models.NotificationOutbox{
+		Kind:          models.OutboxKindPostSubscription,
+		PostID:        &item.ID,
+		NextAttemptAt: lo.FromPtrOr(item.PublishedAt, time.Now()),
+	}
+	return tx.Create(&entry).Error
+}
+
+func GetOutboxBackoff(attempts int) time.Duration {
+	backoff := OutboxBaseBackoff << max(attempts-1, 0)
+	if backoff <= 0 || backoff > OutboxMaxBackoff {
+		return OutboxMaxBackoff
+	}
+	return backoff
+}
+
+func GetOutboxMetrics(db *gorm.DB) OutboxMetrics {
+	metrics := OutboxMetrics{
+		Sent:         outboxSent.Load(),
+		Failed:       outboxFailed.Load(),
+		DeadLettered: outboxDeadLettered.Load(),
+	}
+	db.Model(&models.NotificationOutbox{}).Where("sent_at IS NULL AND dead_at IS NULL").Count(&metrics.Pending)
+	db.Model(&models.NotificationOutbox{}).Where("dead_at IS NOT NULL").Count(&metrics.Dead)
+	return metrics
+}
+
+func DoNotificationOutboxDrain() {
+	if err := DrainNotificationOutbox(database.C, OutboxNotifier); err != nil {
+		log.Error().Err(err).Msg("An error occurred when draining notification outbox...")
+	}
+}
+
+// DrainNotificationOutbox will send all the due entries in the outbox.
+// Failed entries will be retried with exponential backoff, and dead-lettered after OutboxMaxAttempts attempts.
+// The entries are claimed with SKIP LOCKED and leased for OutboxLeaseDuration in a short transaction,
+// so multiple instances can drain the same outbox, and the sending happens outside the transaction.
+func DrainNotificationOutbox(db *gorm.DB, notifier Notifier) error {
+	if !outboxDrainLock.TryLock() {
+		return nil
+	}
+	defer outboxDrainLock.Unlock()
+
+	var processed int
+	for {
+		entries, err := claimOutboxEntries(db)
+		if err != nil {
+			return err
+		}
+
+		for idx := range entries {
+			processOutboxEntry(db, notifier, &entries[idx])
+		}
+
+		processed += len(entries)
+		if len(entries) < OutboxBatchSize {
+			break
+		}
+	}
+
+	if processed > 0 {
+		metrics := GetOutboxMetrics(db)
+		log.Debug().
+			Int("processed", processed).
+			Int64("sent", metrics.Sent).
+			Int64("failed", metrics.Failed).
+			Int64("dead_lettered", metrics.DeadLettered).
+			Int64("pending", metrics.Pending).
+			Msg("Drain notification outbox accomplished.")
+	}
+
+	return nil
+}
+
+// claimOutboxEntries will lease a batch of due entries, the lease expires if the instance stopped before recording the result.
+func claimOutboxEntries(db *gorm.DB) ([]models.NotificationOutbox, error) {
+	var entries []models.NotificationOutbox
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= ?", now).
+			Order("next_attempt_at ASC").
+			Limit(OutboxBatchSize).
+			Find(&entries).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		return tx.Model(&models.NotificationOutbox{}).
+			Where("id IN ?", lo.Map(entries, func(item models.NotificationOutbox, index int) uint {
+				return item.ID
+			})).
+			Update("next_attempt_at", now.Add(OutboxLeaseDuration)).Error
+	})
+	return entries, err
+}
+
+// processOutboxEntry will send the entry and record the result in its own update.
+// The subscription entries are expanded in the same transaction as recording, so they will not be expanded twice.
+func processOutboxEntry(db *gorm.DB, notifier Notifier, entry *models.NotificationOutbox) {
+	var err error
+	switch entry.Kind {
+	case models.OutboxKindPostSubscription:
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := expandPostSubscriptionOutbox(tx, *entry); err != nil {
+				return err
+			}
+			sent := *entry
+			ApplyOutboxResult(&sent, nil)
+			return saveOutboxResult(tx, sent)
+		})
+		if err == nil {
+			ApplyOutboxResult(entry, nil)
+			outboxSent.Add(1)
+			return
+		}
+	default:
+		err = DeliverOutboxEntry(notifier, *entry)
+	}
+
+	ApplyOutboxResult(entry, err)
+	if err := saveOutboxResult(db, *entry); err != nil {
+		log.Error().Err(err).Uint("entry", entry.ID).Msg("An error occurred when recording notification outbox result...")
+		return
+	}
+	if err == nil {
+		outboxSent.Add(1)
+	} else {
+		outboxFailed.Add(1)
+		if entry.DeadAt != nil {
+			outboxDeadLettered.Add(1)
+			log.Warn().Err(err).Uint("entry", entry.ID).Msg("Notification outbox entry has been dead-lettered...")
+		}
+	}
+}
+
+// DeliverOutboxEntry will send the notification entry with the notifier.
+func DeliverOutboxEntry(notifier Notifier, entry models.NotificationOutbox) error {
+	switch entry.Kind {
+	case models.OutboxKindNotification:
+		return notifier.NotifyUserBatch(entry.UserIDs, entry.Notification.Data())
+	default:
+		return fmt.Errorf("unknown outbox kind: %s", entry.Kind)
+	}
+}
+
+// ApplyOutboxResult will update the attempts and the schedule of the entry by the result of the delivery.
+func ApplyOutboxResult(entry *models.NotificationOutbox, err error) {
+	entry.Attempts++
+	if err == nil {
+		entry.SentAt = lo.ToPtr(time.Now())
+		entry.LastError = ""
+		return
+	}
+
+	entry.LastError = err.Error()
+	if entry.Attempts >= OutboxMaxAttempts {
+		entry.DeadAt = lo.ToPtr(time.Now())
+	} else {
+		entry.NextAttemptAt = time.Now().Add(GetOutboxBackoff(entry.Attempts))
+	}
+}
+
+func saveOutboxResult(tx *gorm.DB, entry models.NotificationOutbox) error {
+	return tx.Model(&models.NotificationOutbox{}).
+		Where("id = ?", entry.ID).
+		Updates(map[string]any{
+			"attempts":        entry.Attempts,
+			"sent_at":         entry.SentAt,
+			"dead_at":         entry.DeadAt,
+			"last_error":      entry.LastError,
+			"next_attempt_at": entry.NextAttemptAt,
+		}).Error
+}
+
+func expandPostSubscriptionOutbox(tx *gorm.DB, entry models.NotificationOutbox) error {
+	if entry.PostID == nil {
+		return nil
+	}
+
+	var item models.Post
+	if err := tx.
+		Where("id = ?", *entry.PostID).
+		Preload("Publisher").
+		Preload("Tags").
+		Preload("Categories").
+		Preload("Series").
+		First(&item).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The post was deleted before published, nothing to notify
+			return nil
+		}
+		return err
+	}
+
+	content, ok := item.Body["content"].(string)
+	if !ok {
+		return nil
+	}
+	var title *string
+	if val, ok := item.Body["title"].(string); ok && len(val) > 0 {
+		title = &val
+	}
+
+	pending, err := ListPostSubscriptionNotification(item.Publisher, item, content, title)
+	if err != nil {
+		return err
+	}
+	for _, notification := range pending {
+		if err := EnqueueNotification(tx, notification.UserIDs, notification.Notification); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
+	"gorm.io/datatypes"
+)
+
+type fakeNotifier struct {
+	err   error
+	calls [][]uint64
+}
+
+func (v *fakeNotifier) NotifyUserBatch(userIDs []uint64, notification pushkit.Notification) error {
+	v.calls = append(v.calls, userIDs)
+	return v.err
+}
+
+func newTestOutboxEntry() models.NotificationOutbox {
+	return models.NotificationOutbox{
+		Kind:          models.OutboxKindNotification,
+		UserIDs:       []uint64{1, 2},
+		Notification:  datatypes.NewJSONType(pushkit.Notification{Topic: "test"}),
+		NextAttemptAt: time.Now(),
+	}
+}
+
+func TestDeliverOutboxEntrySent(t *testing.T) {
+	notifier := &fakeNotifier{}
+	entry := newTestOutboxEntry()
+
+	err := DeliverOutboxEntry(notifier, entry)
+	ApplyOutboxResult(&entry, err)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(notifier.calls) != 1 || len(notifier.calls[0]) != 2 {
+		t.Fatalf("expected one call with two users, got %v", notifier.calls)
+	}
+	if entry.SentAt == nil || entry.Attempts != 1 || entry.LastError != "" {
+		t.Fatalf("expected entry to be sent, got %+v", entry)
+	}
+}
+
+func TestDeliverOutboxEntryRetried(t *testing.T) {
+	notifier := &fakeNotifier{err: errors.New("pusher is down")}
+	entry := newTestOutboxEntry()
+
+	before := time.Now()
+	ApplyOutboxResult(&entry, DeliverOutboxEntry(notifier, entry))
+
+	if entry.SentAt != nil || entry.DeadAt != nil {
+		t.Fatalf("expected entry to be retried, got %+v", entry)
+	}
+	if entry.LastError != "pusher is down" {
+		t.Fatalf("unexpected last error: %s", entry.LastError)
+	}
+	if entry.NextAttemptAt.Before(before.Add(OutboxBaseBackoff)) {
+		t.Fatalf("expected the next attempt to be delayed by the backoff, got %s", entry.NextAttemptAt)
+	}
+}
+
+func TestDeliverOutboxEntryDeadLettered(t *testing.T) {
+	notifier := &fakeNotifier{err: errors.New("pusher is down")}
+	entry := newTestOutboxEntry()
+
+	for i := 0; i < OutboxMaxAttempts; i++ {
+		if entry.DeadAt != nil {
+			t.Fatalf("entry dead-lettered too early after %d attempts", entry.Attempts)
+		}
+		ApplyOutboxResult(&entry, DeliverOutboxEntry(notifier, entry))
+	}
+
+	if entry.DeadAt == nil || entry.Attempts != OutboxMaxAttempts {
+		t.Fatalf("expected entry to be dead-lettered, got %+v", entry)
+	}
+	if len(notifier.calls) != OutboxMaxAttempts {
+		t.Fatalf("expected %d calls, got %d", OutboxMaxAttempts, len(notifier.calls))
+	}
+}
+
+func TestDeliverOutboxEntryUnknownKind(t *testing.T) {
+	notifier := &fakeNotifier{}
+	entry := newTestOutboxEntry()
+	entry.Kind = "unknown"
+
+	if err := DeliverOutboxEntry(notifier, entry); err == nil {
+		t.Fatal("expected an error for unknown kind")
+	}
+	if len(notifier.calls) != 0 {
+		t.Fatalf("expected no calls, got %v", notifier.calls)
+	}
+}
+
+func TestGetOutboxBackoff(t *testing.T) {
+	if backoff := GetOutboxBackoff(1); backoff != OutboxBaseBackoff {
+		t.Fatalf("expected %s, got %s", OutboxBaseBackoff, backoff)
+	}
+	if backoff := GetOutboxBackoff(2); backoff != 2*OutboxBaseBackoff {
+		t.Fatalf("expected %s, got %s", 2*OutboxBaseBackoff, backoff)
+	}
+	if backoff := GetOutboxBackoff(64); backoff != OutboxMaxBackoff {
+		t.Fatalf("expected %s, got %s", OutboxMaxBackoff, backoff)
+	}
+}
@@ -1,13 +1,29 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// PollResultNotifyWindow is how long after the expiration the results of a poll will still be notified.
+const PollResultNotifyWindow = 7 * 24 * time.Hour
+
 func NewPoll(poll models.Poll) (models.Poll, error) {
+	if err := ValidatePoll(poll); err != nil {
+		return poll, err
+	}
 	if err := database.C.Create(&poll).Error; err != nil {
 		return poll, err
 	}
@@ -15,54 +31,665 @@ func NewPoll(poll models.Poll) (models.Poll, error) {
 }
 
 func UpdatePoll(poll models.Poll) (models.Poll, error) {
-	if err := database.C.Save(&poll).Error; err != nil {
+	if err := ValidatePoll(poll); err != nil {
+		return poll, err
+	}
+	if err := database.C.Omit("total_answer").Save(&poll).Error; err != nil {
 		return poll, err
 	}
 	return poll, nil
 }
 
+func ValidatePoll(poll models.Poll) error {
+	switch poll.Mode {
+	case models.PollModeSingle, models.PollModeRanked:
+		return nil
+	case models.PollModeMultiple:
+		if poll.MaxChoices < 0 || poll.MaxChoices > len(poll.Options) {
+			return fmt.Errorf("max choices must be between 0 and the count of options")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown poll mode: %d", poll.Mode)
+	}
+}
+
+func IsPollEnded(poll models.Poll) bool {
+	if poll.ClosedAt != nil {
+		return true
+	}
+	return poll.ExpiredAt != nil && time.Now().Unix() >= poll.ExpiredAt.Unix()
+}
+
+func ClosePoll(poll models.Poll) (models.Poll, error) {
+	if poll.ClosedAt != nil {
+		return poll, fmt.Errorf("poll has been closed")
+	}
+
+	poll.ClosedAt = lo.ToPtr(time.Now())
+	if err := database.C.Model(&poll).Update("closed_at", poll.ClosedAt).Error; err != nil {
+		return poll, err
+	}
+
+	go NotifyEndedPolls()
+
+	return poll, nil
+}
+
+// ValidatePollAnswer will check the choices are valid for the poll's mode.
+// For the ranked-choice polls, the choices are in the order of preference.
+func ValidatePollAnswer(poll models.Poll, choices []string) error {
+	if len(choices) == 0 {
+		return fmt.Errorf("answer cannot be empty")
+	}
+	if len(lo.Uniq(choices)) != len(choices) {
+		return fmt.Errorf("answer cannot contain the same option twice")
+	}
+
+	optionIdx := lo.Map(poll.Options, func(item models.PollOption, index int) string {
+		return item.ID
+	})
+	for _, choice := range choices {
+		if !lo.Contains(optionIdx, choice) {
+			return fmt.Errorf("poll does not have a option like that")
+		}
+	}
+
+	switch poll.Mode {
+	case models.PollModeSingle:
+		if len(choices) > 1 {
+			return fmt.Errorf("this poll only accepts one answer")
+		}
+	case models.PollModeMultiple:
+		if poll.MaxChoices > 0 && len(choices) > poll.MaxChoices {
+			return fmt.Errorf("this poll accepts at most %d answers", poll.MaxChoices)
+		}
+	}
+
+	return nil
+}
+
+// lockPoll will lock the poll row until the transaction ends and reload it.
+// Every change of the answers holds this lock, so the tallies always match the answers.
+func lockPoll(tx *gorm.DB, poll *models.Poll) error {
+	return tx.
+		Clauses(clause.Locking{Strength: "NO KEY UPDATE"}).
+		Where("id = ?", poll.ID).
+		First(poll).Error
+}
+
+// GetPollTallyChoices will return the options which an answer counts for.
+// For the ranked-choice polls, only the first choice is counted, the rest are used in the instant-runoff tallying.
+func GetPollTallyChoices(poll models.Poll, choices []string) []string {
+	if len(choices) == 0 {
+		return nil
+	}
+	if poll.Mode == models.PollModeMultiple {
+		return choices
+	}
+	return choices[:1]
+}
+
+func modifyPollTally(tx *gorm.DB, pollID uint, choices []string, delta int64) error {
+	// Always lock the tally rows in the same order to avoid deadlocks
+	choices = lo.Uniq(choices)
+	sort.Strings(choices)
+
+	for _, choice := range choices {
+		tally := models.PollOptionTally{
+			PollID:   pollID,
+			OptionID: choice,
+			Count:    delta,
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "poll_id"}, {Name: "option_id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"count": gorm.Expr("poll_option_tallies.count + ?", delta),
+			}),
+		}).Create(&tally).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func AddPollAnswer(poll models.Poll, answer models.PollAnswer) (models.PollAnswer, error) {
 	answer.PollID = poll.ID
+	if len(answer.Answers) > 0 {
+		answer.Answer = answer.Answers[0]
+	}
+
+	err := database.C.Transaction(func(tx *gorm.DB) error {
+		if err := lockPoll(tx, &poll); err != nil {
+			return err
+		}
+
+		var existing models.PollAnswer
+		if err := tx.
+			Where("poll_id = ? AND account_id = ?", poll.ID, answer.AccountID).
+			First(&existing).Error; err == nil {
+			if !poll.AllowChangeAnswer {
+				return fmt.Errorf("you have already answered this poll")
+			}
+
+			previous := GetPollTallyChoices(poll, GetPollAnswerChoices(existing))
+			existing.Answer = answer.Answer
+			existing.Answers = answer.Answers
+			if err := tx.Save(&existing).Error; err != nil {
+				return fmt.Errorf("failed to update your answer")
+			}
+			if err := modifyPollTally(tx, poll.ID, previous, -1); err != nil {
+				return err
+			}
+			if err := modifyPollTally(tx, poll.ID, GetPollTallyChoices(poll, GetPollAnswerChoices(existing)), 1); err != nil {
+				return err
+			}
+
+			answer = existing
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err := tx.Create(&answer).Error; err != nil {
+			return err
+		}
+		if err := modifyPollTally(tx, poll.ID, GetPollTallyChoices(poll, GetPollAnswerChoices(answer)), 1); err != nil {
+			return err
+		}
+		return tx.Model(&models.Poll{}).
+			Where("id = ?", poll.ID).
+			Update("total_answer", gorm.Expr("total_answer + 1")).Error
+	})
+
+	return answer, err
+}
 
+func RetractPollAnswer(poll models.Poll, userID uint) error {
+	return database.C.Transaction(func(tx *gorm.DB) error {
+		if err := lockPoll(tx, &poll); err != nil {
+			return err
+		}
+
+		var answer models.PollAnswer
+		if err := tx.
+			Where("poll_id = ? AND account_id = ?", poll.ID, userID).
+			First(&answer).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&answer).Error; err != nil {
+			return err
+		}
+		if err := modifyPollTally(tx, poll.ID, GetPollTallyChoices(poll, GetPollAnswerChoices(answer)), -1); err != nil {
+			return err
+		}
+		return tx.Model(&models.Poll{}).
+			Where("id = ?", poll.ID).
+			Update("total_answer", gorm.Expr("total_answer - 1")).Error
+	})
+}
+
+func CountPollAnswer(poll models.Poll) int64 {
 	var count int64
 	if err := database.C.Model(&models.PollAnswer{}).
-		Where("poll_id = ? AND account_id = ?", poll.ID, answer.AccountID).
+		Where("poll_id = ?", poll.ID).
 		Count(&count).Error; err != nil {
-		if err := database.C.Model(&models.PollAnswer{}).Where("poll_id = ? AND account_id = ?", poll.ID, answer.AccountID).Update("answer", answer.Answer).Error; err != nil {
-			return answer, fmt.Errorf("failed to update your answer")
+		return 0
+	}
+	return count
+}
+
+func HasAnsweredPoll(poll models.Poll, userID uint) bool {
+	var count int64
+	if err := database.C.Model(&models.PollAnswer{}).
+		Where("poll_id = ? AND account_id = ?", poll.ID, userID).
+		Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+func GetPollAnswerChoices(answer models.PollAnswer) []string {
+	if len(answer.Answers) > 0 {
+		return answer.Answers
+	} else if len(answer.Answer) > 0 {
+		return []string{answer.Answer}
+	}
+	return nil
+}
+
+// ShouldHidePollResult will check the user can see the result of the poll or not.
+// The owner of the poll can always see the result.
+func ShouldHidePollResult(poll models.Poll, userID *uint, hasAnswered bool) bool {
+	if userID != nil && *userID == poll.AccountID {
+		return false
+	}
+
+	switch poll.ResultVisibility {
+	case models.PollResultAfterAnswered:
+		return !hasAnswered && !IsPollEnded(poll)
+	case models.PollResultAfterEnded:
+		return !IsPollEnded(poll)
+	default:
+		return false
+	}
+}
+
+// HidePollResult will remove the result from the metric when the user is not allowed to see it.
+func HidePollResult(poll *models.Poll, userID *uint, hasAnswered bool) {
+	if ShouldHidePollResult(*poll, userID, hasAnswered) {
+		poll.Metric = models.PollMetric{
+			TotalAnswer: poll.Metric.TotalAnswer,
+			IsHidden:    true,
 		}
+	}
+}
 
-		return answer, nil
+// HydratePostPolls will load the metrics of the polls in the posts, including the replied and reposted ones.
+// For authenticated users, their own answers are loaded in one query as well.
+func HydratePostPolls(items []*models.Post, user *authm.Account) error {
+	var polls []*models.Poll
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		for _, post := range []*models.Post{item, item.ReplyTo, item.RepostTo} {
+			if post != nil && post.Poll != nil {
+				polls = append(polls, post.Poll)
+			}
+		}
 	}
-	if err := database.C.Create(&answer).Error; err != nil {
-		return answer, err
+	if len(polls) == 0 {
+		return nil
 	}
 
-	return answer, nil
+	pollIdx := lo.Uniq(lo.Map(polls, func(item *models.Poll, index int) uint {
+		return item.ID
+	}))
+	metrics := BatchGetPollMetric(lo.Map(polls, func(item *models.Poll, index int) models.Poll {
+		return *item
+	}))
+
+	var userID *uint
+	answers := make(map[uint]models.PollAnswer)
+	if user != nil {
+		userID = &user.ID
+
+		var list []models.PollAnswer
+		if err := database.C.
+			Where("account_id = ? AND poll_id IN ?", user.ID, pollIdx).
+			Find(&list).Error; err != nil {
+			return err
+		}
+		for _, answer := range list {
+			answers[answer.PollID] = answer
+		}
+	}
+
+	for _, poll := range polls {
+		poll.Metric = metrics[poll.ID]
+		if answer, ok := answers[poll.ID]; ok {
+			poll.UserAnswer = &answer
+		}
+		HidePollResult(poll, userID, poll.UserAnswer != nil)
+	}
+
+	return nil
 }
 
 func GetPollMetric(poll models.Poll) models.PollMetric {
+	return BatchGetPollMetric([]models.Poll{poll})[poll.ID]
+}
+
+// BatchGetPollMetric will load the metrics of the polls from the tallies in one query.
+// Only the ranked-choice polls need to load the answers, for the instant-runoff tallying.
+func BatchGetPollMetric(polls []models.Poll) map[uint]models.PollMetric {
+	metrics := make(map[uint]models.PollMetric, len(polls))
+	if len(polls) == 0 {
+		return metrics
+	}
+
+	pollIdx := lo.Map(polls, func(item models.Poll, index int) uint {
+		return item.ID
+	})
+
+	var tallies []models.PollOptionTally
+	if err := database.C.
+		Where("poll_id IN ?", pollIdx).
+		Find(&tallies).Error; err != nil {
+		return metrics
+	}
+	talliesByPoll := lo.GroupBy(tallies, func(item models.PollOptionTally) uint {
+		return item.PollID
+	})
+
+	var reactions []struct {
+		TargetID  uint
+		TargetKey string
+		Symbol    string
+		Count     int64
+	}
+	if err := FilterReactionWithTarget(database.C.Model(&models.Reaction{}), models.ReactionTargetPollOption, pollIdx...).
+		Select("target_id, target_key, symbol, COUNT(id) as count").
+		Group("target_id, target_key, symbol").
+		Scan(&reactions).Error; err != nil {
+		return metrics
+	}
+	reactionsByPoll := make(map[uint]map[string]map[string]int64)
+	for _, info := range reactions {
+		if _, ok := reactionsByPoll[info.TargetID]; !ok {
+			reactionsByPoll[info.TargetID] = make(map[string]map[string]int64)
+		}
+		if _, ok := reactionsByPoll[info.TargetID][info.TargetKey]; !ok {
+			reactionsByPoll[info.TargetID][info.TargetKey] = make(map[string]int64)
+		}
+		reactionsByPoll[info.TargetID][info.TargetKey][info.Symbol] = info.Count
+	}
+
+	ballots := make(map[uint][][]string)
+	rankedIdx := lo.FilterMap(polls, func(item models.Poll, index int) (uint, bool) {
+		return item.ID, item.Mode == models.PollModeRanked
+	})
+	if len(rankedIdx) > 0 {
+		var answers []models.PollAnswer
+		if err := database.C.
+			Select("poll_id", "answer", "answers").
+			Where("poll_id IN ?", rankedIdx).
+			Find(&answers).Error; err != nil {
+			return metrics
+		}
+		for _, answer := range answers {
+			ballots[answer.PollID] = append(ballots[answer.PollID], GetPollAnswerChoices(answer))
+		}
+	}
+
+	for _, poll := range polls {
+		byOptions := make(map[string]int64)
+		for _, option := range poll.Options {
+			byOptions[option.ID] = 0
+		}
+		for _, tally := range talliesByPoll[poll.ID] {
+			if _, ok := byOptions[tally.OptionID]; ok {
+				byOptions[tally.OptionID] = tally.Count
+			}
+		}
+
+		byOptionsPercentage := make(map[string]float64)
+		for _, option := range poll.Options {
+			if poll.TotalAnswer > 0 {
+				byOptionsPercentage[option.ID] = float64(byOptions[option.ID]) / float64(poll.TotalAnswer)
+			} else {
+				byOptionsPercentage[option.ID] = 0
+			}
+		}
+
+		metric := models.PollMetric{
+			TotalAnswer:         poll.TotalAnswer,
+			ByOptions:           byOptions,
+			ByOptionsPercentage: byOptionsPercentage,
+			ReactionList:        reactionsByPoll[poll.ID],
+		}
+
+		if poll.Mode == models.PollModeRanked {
+			metric.Rounds, metric.Winner = TallyInstantRunoff(
+				lo.Map(poll.Options, func(item models.PollOption, index int) string {
+					return item.ID
+				}),
+				ballots[poll.ID],
+			)
+		}
+
+		metrics[poll.ID] = metric
+	}
+
+	return metrics
+}
+
+// RecalculatePollTally will recompute the tallies and the answer count of every poll from the answers.
+// Each poll is locked while recalculating, so the answers submitted at the same time will not be lost.
+func RecalculatePollTally() {
+	log.Debug().Msg("Now recalculating poll tallies...")
+
+	var pollIdx []uint
+	if err := database.C.Model(&models.Poll{}).Pluck("id", &pollIdx).Error; err != nil {
+		log.Error().Err(err).Msg("An error occurred when listing polls...")
+		return
+	}
+
+	var affected int
+	for _, id := range pollIdx {
+		if err := database.C.Transaction(func(tx *gorm.DB) error {
+			return recalculatePollTally(tx, id)
+		}); err != nil {
+			log.Error().Err(err).Uint("poll", id).Msg("An error occurred when recalculating poll tallies...")
+			continue
+		}
+		affected++
+	}
+
+	log.Debug().Int("affected", affected).Msg("Recalculate poll tallies accomplished.")
+}
+
+func recalculatePollTally(tx *gorm.DB, pollID uint) error {
+	var poll models.Poll
+	poll.ID = pollID
+	if err := lockPoll(tx, &poll); err != nil {
+		return err
+	}
+
 	var answers []models.PollAnswer
-	if err := database.C.Where("poll_id = ?", poll.ID).Find(&answers).Error; err != nil {
-		return models.PollMetric{}
+	if err := tx.
+		Select("answer", "answers").
+		Where("poll_id = ?", poll.ID).
+		Find(&answers).Error; err != nil {
+		return err
 	}
 
-	byOptions := make(map[string]int64)
+	counts := make(map[string]int64)
 	for _, answer := range answers {
-		if _, ok := byOptions[answer.Answer]; !ok {
-			byOptions[answer.Answer] = 0
+		for _, choice := range GetPollTallyChoices(poll, GetPollAnswerChoices(answer)) {
+			counts[choice]++
 		}
-		byOptions[answer.Answer]++
 	}
 
-	byOptionsPercentage := make(map[string]float64)
-	for _, option := range poll.Options {
-		byOptionsPercentage[option.ID] = float64(byOptions[option.ID]) / float64(len(answers))
+	if err := tx.Where("poll_id = ?", poll.ID).Delete(&models.PollOptionTally{}).Error; err != nil {
+		return err
+	}
+	if len(counts) > 0 {
+		tallies := make([]models.PollOptionTally, 0, len(counts))
+		for option, count := range counts {
+			tallies = append(tallies, models.PollOptionTally{
+				PollID:   poll.ID,
+				OptionID: option,
+				Count:    count,
+			})
+		}
+		if err := tx.Create(&tallies).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Model(&models.Poll{}).
+		Where("id = ?", poll.ID).
+		Update("total_answer", len(answers)).Error
+}
+
+// TallyInstantRunoff will count the ballots with instant-runoff voting.
+// In each round, every ballot counts for its highest ranked option which is still running,
+// the options with the fewest votes will be eliminated until one option got the majority.
+// When all the remaining options are tied, there will be no winner.
+func TallyInstantRunoff(options []string, ballots [][]string) ([]map[string]int64, *string) {
+	var rounds []map[string]int64
+	running := lo.Uniq(options)
+
+	for len(running) > 0 {
+		round := make(map[string]int64, len(running))
+		for _, option := range running {
+			round[option] = 0
+		}
+
+		var total int64
+		for _, ballot := range ballots {
+			for _, choice := range ballot {
+				if _, ok := round[choice]; ok {
+					round[choice]++
+					total++
+					break
+				}
+			}
+		}
+		rounds = append(rounds, round)
+
+		if total == 0 {
+			return rounds, nil
+		}
+
+		lowest, highest := int64(-1), int64(-1)
+		for _, option := range running {
+			if lowest < 0 || round[option] < lowest {
+				lowest = round[option]
+			}
+			if highest < 0 || round[option] > highest {
+				highest = round[option]
+			}
+		}
+
+		for _, option := range running {
+			if round[option]*2 > total {
+				return rounds, lo.ToPtr(option)
+			}
+		}
+
+		if lowest == highest {
+			// All the remaining options are tied, nobody can be eliminated
+			if len(running) == 1 {
+				return rounds, lo.ToPtr(running[0])
+			}
+			return rounds, nil
+		}
+
+		running = lo.Filter(running, func(item string, index int) bool {
+			return round[item] > lowest
+		})
+	}
+
+	return rounds, nil
+}
+
+func RenderPollResult(poll models.Poll, metric models.PollMetric) string {
+	names := lo.SliceToMap(poll.Options, func(item models.PollOption) (string, string) {
+		return item.ID, item.Name
+	})
+
+	if poll.Mode == models.PollModeRanked {
+		if metric.Winner != nil {
+			return fmt.Sprintf("The winner is %s after %d rounds.", names[*metric.Winner], len(metric.Rounds))
+		}
+		return "There is no winner, the options are tied."
+	}
+
+	options := make([]models.PollOption, len(poll.Options))
+	copy(options, poll.Options)
+	sort.SliceStable(options, func(i, j int) bool {
+		return metric.ByOptions[options[i].ID] > metric.ByOptions[options[j].ID]
+	})
+
+	lines := make([]string, 0, len(options))
+	for _, option := range options {
+		lines = append(lines, fmt.Sprintf(
+			"%s: %d (%.0f%%)",
+			option.Name,
+			metric.ByOptions[option.ID],
+			metric.ByOptionsPercentage[option.ID]*100,
+		))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// NotifyEndedPolls will notify the voters and the author of the polls which are closed or expired with the final results.
+// Each poll will only be notified once, and the polls expired long ago will be skipped.
+func NotifyEndedPolls() {
+	now := time.Now()
+
+	var polls []models.Poll
+	if err := database.C.
+		Where("notified_at IS NULL").
+		Where("closed_at IS NOT NULL OR (expired_at <= ? AND expired_at > ?)", now, now.Add(-PollResultNotifyWindow)).
+		Find(&polls).Error; err != nil {
+		log.Error().Err(err).Msg("An error occurred when listing ended polls...")
+		return
+	}
+
+	for _, poll := range polls {
+		metric := GetPollMetric(poll)
+		body := RenderPollResult(poll, metric)
+
+		var voters []uint
+		if err := database.C.Model(&models.PollAnswer{}).
+			Where("poll_id = ?", poll.ID).
+			Pluck("account_id", &voters).Error; err != nil {
+			log.Error().Err(err).Uint("poll", poll.ID).Msg("An error occurred when listing poll voters...")
+			continue
+		}
+
+		var post models.Post
+		hasPost := database.C.Where("poll_id = ?", poll.ID).Preload("Publisher").First(&post).Error == nil
+
+		if err := database.C.Transaction(func(tx *gorm.DB) error {
+			// Claim the poll first, the other run notifying the same poll will wait for this one and find it claimed
+			claim := tx.Model(&models.Poll{}).
+				Where("id = ? AND notified_at IS NULL", poll.ID).
+				Update("notified_at", time.Now())
+			if claim.Error != nil {
+				return claim.Error
+			} else if claim.RowsAffected != 1 {
+				return nil
+			}
+
+			author := poll.AccountID
+			if hasPost && post.Publisher.AccountID != nil {
+				author = *post.Publisher.AccountID
+			}
+
+			metadata := map[string]any{"poll_id": poll.ID}
+			if hasPost {
+				metadata["related_post"] = TruncatePostContent(post)
+			}
+
+			if err := EnqueueNotification(tx, []uint64{uint64(author)}, pushkit.Notification{
+				Topic:    "interactive.poll.ended",
+				Title:    "Your poll has ended",
+				Subtitle: fmt.Sprintf("%d people answered your poll", metric.TotalAnswer),
+				Body:     body,
+				Priority: 4,
+				Metadata: metadata,
+			}); err != nil {
+				return err
+			}
+
+			voterIDs := lo.FilterMap(voters, func(item uint, index int) (uint64, bool) {
+				return uint64(item), item != author
+			})
+			if err := EnqueueNotification(tx, voterIDs, pushkit.Notification{
+				Topic:    "interactive.poll.ended",
+				Title:    "A poll you answered has ended",
+				Subtitle: "Here are the final results",
+				Body:     body,
+				Priority: 3,
+				Metadata: metadata,
+			}); err != nil {
+				return err
+			}
+
+			return nil
+		}); err != nil {
+			log.Error().Err(err).Uint("poll", poll.ID).Msg("An error occurred when notifying the result of the poll...")
+		}
 	}
 
-	return models.PollMetric{
-		TotalAnswer:         int64(len(answers)),
-		ByOptions:           byOptions,
-		ByOptionsPercentage: byOptionsPercentage,
+	if len(polls) > 0 {
+		log.Debug().Int("count", len(polls)).Msg("Notified the results of ended polls.")
 	}
 }
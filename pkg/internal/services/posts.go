@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
@@ -25,6 +24,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func FilterPostWithUserContext(tx *gorm.DB, user *authm.Account) *gorm.DB {
@@ -151,6 +151,40 @@ func FilterPostWithType(tx *gorm.DB, t string) *gorm.DB {
 	return tx.Where("type = ?", t)
 }
 
+// FilterPostWithLanguage will keep the posts in the languages divided by comma,
+// the preferred in the languages will be replaced with the preferred languages of the user.
+func FilterPostWithLanguage(tx *gorm.DB, languages string, user *authm.Account) (*gorm.DB, error) {
+	var filter []string
+	for _, lang := range strings.Split(languages, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang == "preferred" {
+			filter = append(filter, GetPreferredLanguages(user)...)
+		} else if len(lang) > 0 {
+			code, err := ParseLanguage(lang)
+			if err != nil {
+				return tx, err
+			}
+			filter = append(filter, code)
+		}
+	}
+	if len(filter) == 0 {
+		return tx, nil
+	}
+	return tx.Where("language IN ?", lo.Uniq(filter)), nil
+}
+
+// ShufflePostWithLanguages will return the order which shuffles the posts,
+// the posts in the languages were given a higher weight, so they are more likely to come first.
+func ShufflePostWithLanguages(languages []string) any {
+	if len(languages) == 0 {
+		return "RANDOM()"
+	}
+	return clause.OrderBy{Expression: clause.Expr{
+		SQL:  "RANDOM() * (CASE WHEN language IN ? THEN 2 ELSE 1 END) DESC",
+		Vars: []any{languages},
+	}}
+}
+
 func FilterPostReply(tx *gorm.DB, replyTo ...uint) *gorm.DB {
 	if len(replyTo) > 0 && replyTo[0] > 0 {
 		return tx.Where("reply_id = ?", replyTo[0])
@@ -190,14 +224,19 @@ func PreloadGeneral(tx *gorm.DB) *gorm.DB {
 		Preload("Tags").
 		Preload("Categories").
 		Preload("Publisher").
+		Preload("Poll").
+		Preload("Series").
+		Preload("LinkPreviews").
 		Preload("ReplyTo").
 		Preload("ReplyTo.Publisher").
 		Preload("ReplyTo.Tags").
 		Preload("ReplyTo.Categories").
+		Preload("ReplyTo.Poll").
 		Preload("RepostTo").
 		Preload("RepostTo.Publisher").
 		Preload("RepostTo.Tags").
-		Preload("RepostTo.Categories")
+		Preload("RepostTo.Categories").
+		Preload("RepostTo.Poll")
 }
 
 func GetPost(tx *gorm.DB, id uint, ignoreLimitation ...bool) (models.Post, error) {
@@ -231,6 +270,19 @@ func GetPostByAlias(tx *gorm.DB, alias, area string, ignoreLimitation ...bool) (
 	return item, nil
 }
 
+// GetPostByIdentifier will find the post by the numeric id or the area:alias.
+func GetPostByIdentifier(tx *gorm.DB, id string, ignoreLimitation ...bool) (models.Post, error) {
+	if numericId, err := strconv.Atoi(id); err == nil {
+		return GetPost(tx, uint(numericId), ignoreLimitation...)
+	}
+
+	segments := strings.Split(id, ":")
+	if len(segments) != 2 {
+		return models.Post{}, fmt.Errorf("invalid post id, must be a number or a string with two segment divided by a colon")
+	}
+	return GetPostByAlias(tx, segments[1], segments[0], ignoreLimitation...)
+}
+
 func CountPost(tx *gorm.DB) (int64, error) {
 	var count int64
 	if err := tx.Model(&models.Post{}).Count(&count).Error; err != nil {
@@ -262,7 +314,7 @@ func CountPostReactions(id uint) int64 {
 	return count
 }
 
-func ListPost(tx *gorm.DB, take int, offset int, order any, noReact ...bool) ([]*models.Post, error) {
+func ListPost(tx *gorm.DB, take int, offset int, order any, user *authm.Account, noReact ...bool) ([]*models.Post, error) {
 	if take > 100 {
 		take = 100
 	}
@@ -281,7 +333,7 @@ func ListPost(tx *gorm.DB, take int, offset int, order any, noReact ...bool) ([]
 
 	// Load reactions
 	if len(noReact) <= 0 || !noReact[0] {
-		if mapping, err := BatchListPostReactions(database.C.Where("post_id IN ?", idx), "post_id"); err != nil {
+		if mapping, err := BatchListReactions[uint](database.C.Where("post_id IN ?", idx), "post_id"); err != nil {
 			return items, err
 		} else {
 			itemMap := lo.SliceToMap(items, func(item *models.Post) (uint, *models.Post) {
@@ -332,6 +384,22 @@ func ListPost(tx *gorm.DB, take int, offset int, order any, noReact ...bool) ([]
 		}
 	}
 
+	// Load the user's own reactions
+	if user != nil && (len(noReact) <= 0 || !noReact[0]) {
+		if mapping, err := BatchListUserReactions(user.ID, idx); err != nil {
+			return items, err
+		} else {
+			for _, item := range items {
+				item.MyReactions = mapping[item.ID]
+			}
+		}
+	}
+
+	// Load polls
+	if err := HydratePostPolls(items, user); err != nil {
+		return items, err
+	}
+
 	return items, nil
 }
 
@@ -389,69 +457,73 @@ func NewPost(user models.Publisher, item models.Post) (models.Post, error) {
 		item.AliasPrefix = &user.Name
 	}
 
+	ComputeArticleMetadata(item)
+
+	item, err := ResolvePostLanguage(item)
+	if err != nil {
+		return item, err
+	}
+
 	log.Debug().Any("body", item.Body).Msg("Posting a post...")
 	start := time.Now()
 
 	log.Debug().Any("tags", item.Tags).Any("categories", item.Categories).Msg("Preparing categories and tags...")
-	item, err := EnsurePostCategoriesAndTags(item)
+	item, err = EnsurePostCategoriesAndTags(item)
 	if err != nil {
 		return item, err
 	}
 
 	log.Debug().Msg("Saving post record into database...")
-	if err := database.C.Save(&item).Error; err != nil {
-		return item, err
-	}
-
-	item.Publisher = user
-	_ = updatePostAttachmentVisibility(item)
+	if err := database.C.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
 
-	// Notify the original poster its post has been replied
-	if item.ReplyID != nil {
-		var op models.Post
-		if err := database.C.
-			Where("id = ?", item.ReplyID).
-			Preload("Publisher").
-			First(&op).Error; err == nil {
-			if op.Publisher.AccountID != nil && op.Publisher.ID != user.ID {
-				log.Debug().Uint("user", *op.Publisher.AccountID).Msg("Notifying the original poster their post got replied...")
-				err = NotifyPosterAccount(
-					op.Publisher,
-					op,
-					"Post got replied",
-					fmt.Sprintf("%s (%s) replied your post (#%d).", user.Nick, user.Name, op.ID),
-					"interactive.reply",
-					fmt.Sprintf("%s replied you", user.Nick),
-				)
-				if err != nil {
-					log.Error().Err(err).Msg("An error occurred when notifying user...")
+		// Notify the original poster its post has been replied
+		if item.ReplyID != nil {
+			var op models.Post
+			if err := tx.
+				Where("id = ?", item.ReplyID).
+				Preload("Publisher").
+				First(&op).Error; err == nil {
+				if op.Publisher.AccountID != nil && op.Publisher.ID != user.ID {
+					log.Debug().Uint("user", *op.Publisher.AccountID).Msg("Notifying the original poster their post got replied...")
+					if err := EnqueueNotification(
+						tx,
+						[]uint64{uint64(*op.Publisher.AccountID)},
+						BuildPosterNotification(
+							op.Publisher,
+							op,
+							"Post got replied",
+							fmt.Sprintf("%s (%s) replied your post (#%d).", user.Nick, user.Name, op.ID),
+							"interactive.reply",
+							fmt.Sprintf("%s replied you", user.Nick),
+						),
+					); err != nil {
+						return err
+					}
 				}
 			}
 		}
-	}
 
-	// Notify the subscriptions
-	if content, ok := item.Body["content"].(string); ok {
-		var title *string
-		title, _ = item.Body["title"].(*string)
-		go func() {
-			item.Publisher = user
-			if err := NotifyUserSubscription(user, item, content, title); err != nil {
-				log.Error().Err(err).Msg("An error occurred when notifying subscriptions user by user...")
+		// Notify the subscriptions
+		if _, ok := item.Body["content"].(string); ok && !item.IsDraft {
+			if err := EnqueuePostSubscription(tx, item); err != nil {
+				return err
 			}
-			for _, tag := range item.Tags {
-				if err := NotifyTagSubscription(tag, user, item, content, title); err != nil {
-					log.Error().Err(err).Msg("An error occurred when notifying subscriptions user by tag...")
-				}
-			}
-			for _, category := range item.Categories {
-				if err := NotifyCategorySubscription(category, user, item, content, title); err != nil {
-					log.Error().Err(err).Msg("An error occurred when notifying subscriptions user by category...")
-				}
-			}
-		}()
+		}
+
+		return nil
+	}); err != nil {
+		return item, err
 	}
 
+	item.Publisher = user
+	_ = updatePostAttachmentVisibility(item)
+
+	go DoNotificationOutboxDrain()
+	go UpdatePostLinkPreviews(item)
+
 	log.Debug().Dur("elapsed", time.Since(start)).Msg("The post is posted.")
 	return item, nil
 }
@@ -478,7 +550,14 @@ func EditPost(item models.Post) (models.Post, error) {
 		item.AliasPrefix = &item.Publisher.Name
 	}
 
-	item, err := EnsurePostCategoriesAndTags(item)
+	ComputeArticleMetadata(item)
+
+	item, err := ResolvePostLanguage(item)
+	if err != nil {
+		return item, err
+	}
+
+	item, err = EnsurePostCategoriesAndTags(item)
 	if err != nil {
 		return item, err
 	}
@@ -486,12 +565,40 @@ func EditPost(item models.Post) (models.Post, error) {
 	_ = database.C.Model(&item).Association("Categories").Replace(item.Categories)
 	_ = database.C.Model(&item).Association("Tags").Replace(item.Tags)
 
+	// The subscribers were notified once the draft is published, the new posts in draft were not enqueued
+	var wasDraft bool
+	database.C.Model(&models.Post{}).Select("is_draft").Where("id = ?", item.ID).Scan(&wasDraft)
+
 	pub := item.Publisher
-	err = database.C.Save(&item).Error
+	err = database.C.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		if _, ok := item.Body["content"].(string); ok && wasDraft && !item.IsDraft {
+			return EnqueuePostSubscription(tx, item)
+		}
+		return nil
+	})
 
 	if err == nil {
 		item.Publisher = pub
 		_ = updatePostAttachmentVisibility(item)
+
+		if item.Type == models.PostTypeArticle {
+			if err := ReanchorPostAnnotations(item); err != nil {
+				log.Error().Err(err).Uint("post", item.ID).Msg("An error occurred when re-anchoring post annotations...")
+			}
+		}
+
+		if err := InvalidatePostTranslations(item); err != nil {
+			log.Error().Err(err).Uint("post", item.ID).Msg("An error occurred when invalidating post translations...")
+		}
+		if err := InvalidatePostInsights(item); err != nil {
+			log.Error().Err(err).Uint("post", item.ID).Msg("An error occurred when invalidating post insights...")
+		}
+
+		go DoNotificationOutboxDrain()
+		go UpdatePostLinkPreviews(item)
 	}
 
 	return item, err
@@ -566,64 +673,83 @@ func DeletePost(item models.Post) error {
 	return nil
 }
 
-func ReactPost(user authm.Account, reaction models.Reaction) (bool, models.Reaction, error) {
-	var op models.Post
-	if err := database.C.
-		Where("id = ?", reaction.PostID).
-		Preload("Publisher").
-		First(&op).Error; err != nil {
-		return true, reaction, err
-	}
-
-	if err := database.C.Where(reaction).First(&reaction).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			if op.Publisher.AccountID != nil && *op.Publisher.AccountID != user.ID {
-				err = NotifyPosterAccount(
-					op.Publisher,
-					op,
-					"Post got reacted",
-					fmt.Sprintf("%s (%s) reacted your post a %s.", user.Nick, user.Name, reaction.Symbol),
-					"interactive.feedback",
-					fmt.Sprintf("%s reacted you", user.Nick),
-				)
-				if err != nil {
-					log.Error().Err(err).Msg("An error occurred when notifying user...")
-				}
-			}
-
-			err = database.C.Save(&reaction).Error
-			if err == nil && reaction.Attitude != models.AttitudeNeutral {
-				_ = ModifyPosterVoteCount(op.Publisher, reaction.Attitude == models.AttitudePositive, 1)
+// ReactPost will toggle the reaction of the user on the target, the op is the post which the target belongs to.
+// The reaction and the vote counters are changed in one transaction, and the counters are increased on the database side,
+// so the concurrent reactions will not lose updates or create duplicated reactions.
+// Only the reactions to the post itself will be counted as votes and notified.
+func ReactPost(user authm.Account, op models.Post, reaction models.Reaction) (bool, models.Reaction, error) {
+	if reaction.TargetType == models.ReactionTargetPost {
+		reaction.TargetID = op.ID
+		reaction.PostID = &op.ID
+	}
+
+	var created bool
+	if err := database.C.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction)
+		if result.Error != nil {
+			return result.Error
+		}
 
-				if reaction.Attitude == models.AttitudePositive {
-					op.TotalUpvote++
-					database.C.Model(&op).Update("total_upvote", op.TotalUpvote)
-				} else {
-					op.TotalDownvote++
-					database.C.Model(&op).Update("total_downvote", op.TotalDownvote)
-				}
+		delta := 1
+		if result.RowsAffected > 0 {
+			created = true
+		} else {
+			// Already reacted, remove the reaction instead
+			var removed []models.Reaction
+			result = tx.Clauses(clause.Returning{}).
+				Where("target_type = ? AND target_id = ? AND target_key = ?", reaction.TargetType, reaction.TargetID, reaction.TargetKey).
+				Where("account_id = ? AND symbol = ?", reaction.AccountID, reaction.Symbol).
+				Delete(&removed)
+			if result.Error != nil {
+				return result.Error
+			} else if len(removed) == 0 {
+				// Removed by another request at the same time
+				return nil
 			}
+			reaction = removed[0]
+			delta = -1
+		}
 
-			return true, reaction, err
-		} else {
-			return true, reaction, err
+		if reaction.TargetType != models.ReactionTargetPost {
+			return nil
 		}
-	} else {
-		err = database.C.Delete(&reaction).Error
-		if err == nil && reaction.Attitude != models.AttitudeNeutral {
-			_ = ModifyPosterVoteCount(op.Publisher, reaction.Attitude == models.AttitudePositive, -1)
-
-			if reaction.Attitude == models.AttitudePositive {
-				op.TotalUpvote--
-				database.C.Model(&op).Update("total_upvote", op.TotalUpvote)
-			} else {
-				op.TotalDownvote--
-				database.C.Model(&op).Update("total_downvote", op.TotalDownvote)
-			}
+		return modifyPostVoteCount(tx, op, reaction.Attitude, delta)
+	}); err != nil {
+		return created, reaction, err
+	}
+
+	if created && reaction.TargetType == models.ReactionTargetPost &&
+		op.Publisher.AccountID != nil && *op.Publisher.AccountID != user.ID {
+		err := NotifyPosterAccount(
+			op.Publisher,
+			op,
+			"Post got reacted",
+			fmt.Sprintf("%s (%s) reacted your post a %s.", user.Nick, user.Name, reaction.Symbol),
+			"interactive.feedback",
+			fmt.Sprintf("%s reacted you", user.Nick),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("An error occurred when notifying user...")
 		}
+	}
 
-		return false, reaction, err
+	return created, reaction, nil
+}
+
+func modifyPostVoteCount(tx *gorm.DB, post models.Post, attitude models.ReactionAttitude, delta int) error {
+	if attitude == models.AttitudeNeutral {
+		return nil
 	}
+
+	isUpvote := attitude == models.AttitudePositive
+	column := lo.Ternary(isUpvote, "total_upvote", "total_downvote")
+	if err := tx.Model(&models.Post{}).
+		Where("id = ?", post.ID).
+		Update(column, gorm.Expr(column+" + ?", delta)).Error; err != nil {
+		return err
+	}
+
+	return ModifyPosterVoteCount(tx, post.Publisher, isUpvote, delta)
 }
 
 func PinPost(post models.Post) (bool, error) {
@@ -647,8 +773,12 @@ func TruncatePostContent(post models.Post) models.Post {
 			length := TruncatePostContentThreshold
 			post.Body["content_length"] = len([]rune(val))
 			if len([]rune(val)) >= length {
-				post.Body["content"] = string([]rune(val)[:length]) + "..."
-				post.Body["content_truncated"] = true
+				// Cut the plain text instead of the markdown, to avoid breaking the syntax
+				if plain := []rune(RenderMarkdownPlainText(val)); len(plain) >= length {
+					post.Body["content"] = string(plain[:length]) + "..."
+					post.Body["content_truncated"] = true
+					delete(post.Body, "blocks")
+				}
 			}
 		}
 	}
@@ -666,6 +796,7 @@ func TruncatePostContent(post models.Post) models.Post {
 const TruncatePostContentShortThreshold = 80
 
 func TruncatePostContentShort(content string) string {
+	content = RenderMarkdownPlainText(content)
 	length := TruncatePostContentShortThreshold
 	if len([]rune(content)) >= length {
 		return string([]rune(content)[:length]) + "..."
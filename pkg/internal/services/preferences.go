@@ -0,0 +1,72 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const PreferredLanguagesMax = 10
+
+// GetAccountPreference will return the preference of the user, the default one will be returned if the user never set it.
+func GetAccountPreference(accountId uint) (models.AccountPreference, error) {
+	var preference models.AccountPreference
+	if err := database.C.Where("account_id = ?", accountId).First(&preference).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.AccountPreference{AccountID: accountId}, nil
+		}
+		return preference, err
+	}
+	return preference, nil
+}
+
+// GetPreferredLanguages will return the preferred languages of the user, nil will be returned for guests.
+func GetPreferredLanguages(user *authm.Account) []string {
+	if user == nil {
+		return nil
+	}
+	preference, err := GetAccountPreference(user.ID)
+	if err != nil {
+		return nil
+	}
+	return preference.Languages
+}
+
+// SetPreferredLanguages will replace the preferred languages of the user, the languages are stored as ISO 639-1 codes.
+func SetPreferredLanguages(accountId uint, languages []string) (models.AccountPreference, error) {
+	var codes []string
+	for _, item := range languages {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		code, err := ParseLanguage(item)
+		if err != nil {
+			return models.AccountPreference{}, err
+		}
+		codes = append(codes, code)
+	}
+	languages = lo.Uniq(codes)
+	if len(languages) > PreferredLanguagesMax {
+		return models.AccountPreference{}, fmt.Errorf("too many languages, the maximum is %d", PreferredLanguagesMax)
+	}
+
+	preference := models.AccountPreference{
+		AccountID: accountId,
+		Languages: languages,
+	}
+	if err := database.C.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "account_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"languages", "updated_at"}),
+	}).Create(&preference).Error; err != nil {
+		return preference, err
+	}
+
+	return GetAccountPreference(accountId)
+}
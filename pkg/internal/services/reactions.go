@@ -3,12 +3,36 @@ package services
 import (
 	"fmt"
 
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"git.solsynth.dev/hypernet/nexus/pkg/proto"
+	"git.solsynth.dev/hypernet/passport/pkg/authkit"
+	authm "git.solsynth.dev/hypernet/passport/pkg/authkit/models"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
 )
 
-func ListPostReactions(tx *gorm.DB) (map[string]int64, error) {
+func FilterReactionWithTarget(tx *gorm.DB, targetType models.ReactionTargetType, targetID ...uint) *gorm.DB {
+	tx = tx.Where("target_type = ?", targetType)
+	if len(targetID) == 1 {
+		tx = tx.Where("target_id = ?", targetID[0])
+	} else if len(targetID) > 1 {
+		tx = tx.Where("target_id IN ?", targetID)
+	}
+	return tx
+}
+
+// GetUserReaction will find the reaction of the user on the target with the symbol.
+func GetUserReaction(targetType models.ReactionTargetType, targetID uint, targetKey string, userID uint, symbol string) (models.Reaction, error) {
+	var reaction models.Reaction
+	err := FilterReactionWithTarget(database.C, targetType, targetID).
+		Where("target_key = ? AND account_id = ? AND symbol = ?", targetKey, userID, symbol).
+		First(&reaction).Error
+	return reaction, err
+}
+
+func ListReactions(tx *gorm.DB) (map[string]int64, error) {
 	var reactions []struct {
 		Symbol string
 		Count  int64
@@ -30,14 +54,15 @@ func ListPostReactions(tx *gorm.DB) (map[string]int64, error) {
 	}), nil
 }
 
-func BatchListPostReactions(tx *gorm.DB, indexField string) (map[uint]map[string]int64, error) {
+// BatchListReactions will count the reactions grouped by the index field, such as post_id, target_id or target_key.
+func BatchListReactions[K comparable](tx *gorm.DB, indexField string) (map[K]map[string]int64, error) {
 	var reactions []struct {
-		ID     uint
+		ID     K
 		Symbol string
 		Count  int64
 	}
 
-	reactInfo := map[uint]map[string]int64{}
+	reactInfo := map[K]map[string]int64{}
 	if err := tx.Model(&models.Reaction{}).
 		Select(fmt.Sprintf("%s as id, symbol, COUNT(*) as count", indexField)).
 		Group("id, symbol").
@@ -58,3 +83,85 @@ func BatchListPostReactions(tx *gorm.DB, indexField string) (map[uint]map[string
 
 	return reactInfo, nil
 }
+
+// BatchListUserReactions will list the symbols the user reacted on each post.
+func BatchListUserReactions(userID uint, postIdx []uint) (map[uint][]string, error) {
+	if len(postIdx) == 0 {
+		return map[uint][]string{}, nil
+	}
+
+	tx := database.C.Select("target_id", "symbol").Where("account_id = ?", userID)
+	tx = FilterReactionWithTarget(tx, models.ReactionTargetPost, postIdx...)
+
+	var reactions []models.Reaction
+	if err := tx.Find(&reactions).Error; err != nil {
+		return nil, err
+	}
+
+	mapping := make(map[uint][]string)
+	for _, reaction := range reactions {
+		mapping[reaction.TargetID] = append(mapping[reaction.TargetID], reaction.Symbol)
+	}
+	return mapping, nil
+}
+
+// FilterReactorWithUserContext will hide the reactors who blocked the user or got blocked by the user.
+func FilterReactorWithUserContext(tx *gorm.DB, user *authm.Account) *gorm.DB {
+	if user == nil {
+		return tx
+	}
+
+	userGotBlocked, _ := authkit.ListRelative(gap.Nx, user.ID, int32(authm.RelationshipBlocked), true)
+	userBlocked, _ := authkit.ListRelative(gap.Nx, user.ID, int32(authm.RelationshipBlocked), false)
+	blocklist := lo.Uniq(lo.Map(append(userGotBlocked, userBlocked...), func(item *proto.UserInfo, index int) uint {
+		return uint(item.GetId())
+	}))
+
+	if len(blocklist) > 0 {
+		tx = tx.Where("account_id NOT IN ?", blocklist)
+	}
+	return tx
+}
+
+func CountPostReactor(tx *gorm.DB) (int64, error) {
+	var count int64
+	if err := tx.Model(&models.Reaction{}).Count(&count).Error; err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// ListPostReactor will list the reactions with the reactor's account info.
+func ListPostReactor(tx *gorm.DB, take int, offset int) ([]models.Reaction, error) {
+	if take > 100 {
+		take = 100
+	}
+
+	var reactions []models.Reaction
+	if err := tx.
+		Limit(take).Offset(offset).
+		Order("created_at DESC").
+		Find(&reactions).Error; err != nil {
+		return reactions, err
+	}
+	if len(reactions) == 0 {
+		return reactions, nil
+	}
+
+	accounts, err := authkit.ListUser(gap.Nx, lo.Uniq(lo.Map(reactions, func(item models.Reaction, index int) uint {
+		return item.AccountID
+	})))
+	if err != nil {
+		return reactions, fmt.Errorf("unable to get reactors info: %v", err)
+	}
+	accountMap := lo.SliceToMap(accounts, func(item authm.Account) (uint, authm.Account) {
+		return item.ID, item
+	})
+	for idx := range reactions {
+		if account, ok := accountMap[reactions[idx].AccountID]; ok {
+			reactions[idx].Account = &account
+		}
+	}
+
+	return reactions, nil
+}
@@ -0,0 +1,153 @@
+package services
+
+import (
+	"fmt"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+func GetSeries(id uint) (models.Series, error) {
+	var series models.Series
+	if err := database.C.Where("id = ?", id).Preload("Publisher").First(&series).Error; err != nil {
+		return series, err
+	}
+	return series, nil
+}
+
+func GetSeriesWithPublisher(id uint, publisher models.Publisher) (models.Series, error) {
+	var series models.Series
+	if err := database.C.Where("id = ? AND publisher_id = ?", id, publisher.ID).First(&series).Error; err != nil {
+		return series, fmt.Errorf("unable to get series: %v", err)
+	}
+	return series, nil
+}
+
+func ListSeries(publisher models.Publisher) ([]models.Series, error) {
+	var series []models.Series
+	if err := database.C.Where("publisher_id = ?", publisher.ID).Order("created_at DESC").Find(&series).Error; err != nil {
+		return series, err
+	}
+	return series, nil
+}
+
+func NewSeries(series models.Series) (models.Series, error) {
+	if err := database.C.Create(&series).Error; err != nil {
+		return series, err
+	}
+	return series, nil
+}
+
+func EditSeries(series models.Series) (models.Series, error) {
+	if err := database.C.Save(&series).Error; err != nil {
+		return series, err
+	}
+	return series, nil
+}
+
+// DeleteSeries will delete the series, the articles in it will be kept as standalone articles.
+func DeleteSeries(series models.Series) error {
+	return database.C.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).
+			Where("series_id = ?", series.ID).
+			Updates(map[string]any{"series_id": nil, "series_order": 0}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("series_id = ?", series.ID).Delete(&models.Subscription{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&series).Error
+	})
+}
+
+// GetSeriesNextOrder will return the order for the article appended to the end of the series.
+func GetSeriesNextOrder(series models.Series) int {
+	var order int
+	database.C.Model(&models.Post{}).
+		Where("series_id = ?", series.ID).
+		Select("COALESCE(MAX(series_order), 0)").
+		Scan(&order)
+	return order + 1
+}
+
+// ReorderSeries will make the articles become the series in the given order.
+// The articles that were in the series but not in the list will be removed from the series.
+func ReorderSeries(series models.Series, postIdx []uint) error {
+	if len(lo.Uniq(postIdx)) != len(postIdx) {
+		return fmt.Errorf("series cannot contain the same article twice")
+	}
+
+	var count int64
+	if err := database.C.Model(&models.Post{}).
+		Where("id IN ? AND publisher_id = ? AND type = ?", postIdx, series.PublisherID, models.PostTypeArticle).
+		Count(&count).Error; err != nil {
+		return err
+	} else if int(count) != len(postIdx) {
+		return fmt.Errorf("series can only contain the articles of its publisher")
+	}
+
+	return database.C.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).
+			Where("series_id = ? AND id NOT IN ?", series.ID, append(postIdx, 0)).
+			Updates(map[string]any{"series_id": nil, "series_order": 0}).Error; err != nil {
+			return err
+		}
+		for idx, id := range postIdx {
+			if err := tx.Model(&models.Post{}).
+				Where("id = ?", id).
+				Updates(map[string]any{"series_id": series.ID, "series_order": idx + 1}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPostSeriesNavigation will find the previous and next articles of the post in its series.
+// The tx should be filtered with the user context, so the articles the user cannot see will be skipped.
+func GetPostSeriesNavigation(tx *gorm.DB, post models.Post) (*models.PostSeriesNavigation, error) {
+	if post.SeriesID == nil {
+		return nil, nil
+	}
+
+	toEntry := func(item models.Post) *models.PostSeriesEntry {
+		title, _ := item.Body["title"].(string)
+		return &models.PostSeriesEntry{
+			ID:          item.ID,
+			Alias:       item.Alias,
+			AliasPrefix: item.AliasPrefix,
+			Title:       title,
+			SeriesOrder: item.SeriesOrder,
+		}
+	}
+
+	var navigation models.PostSeriesNavigation
+
+	var prev []models.Post
+	if err := tx.Session(&gorm.Session{}).
+		Select("id", "alias", "alias_prefix", "body", "series_order").
+		Where("series_id = ? AND series_order < ?", *post.SeriesID, post.SeriesOrder).
+		Order("series_order DESC").
+		Limit(1).
+		Find(&prev).Error; err != nil {
+		return nil, err
+	} else if len(prev) > 0 {
+		navigation.Prev = toEntry(prev[0])
+	}
+
+	var next []models.Post
+	if err := tx.Session(&gorm.Session{}).
+		Select("id", "alias", "alias_prefix", "body", "series_order").
+		Where("series_id = ? AND series_order > ?", *post.SeriesID, post.SeriesOrder).
+		Order("series_order ASC").
+		Limit(1).
+		Find(&next).Error; err != nil {
+		return nil, err
+	} else if len(next) > 0 {
+		navigation.Next = toEntry(next[0])
+	}
+
+	return &navigation, nil
+}
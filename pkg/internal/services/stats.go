@@ -0,0 +1,191 @@
+package services
+
+import (
+	"time"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const statsSeriesDateLayout = "2006-01-02"
+
+// GetPublisherStats will summarize the activities of the publisher in the recent days.
+// The follower data is left empty unless withFollowers is true, it is hidden when the publisher turned on HideFollowers.
+func GetPublisherStats(publisher models.Publisher, days int, withFollowers bool) (models.PublisherStats, error) {
+	stats := models.PublisherStats{
+		PostCountByType: make(map[string]int64),
+	}
+
+	postTx := func() *gorm.DB {
+		return FilterPostDraft(database.C.Model(&models.Post{}).Where("publisher_id = ?", publisher.ID))
+	}
+
+	var postTypes []struct {
+		Type  string
+		Count int64
+	}
+	if err := postTx().
+		Select("type, COUNT(id) as count").
+		Group("type").
+		Scan(&postTypes).Error; err != nil {
+		return stats, err
+	}
+	for _, info := range postTypes {
+		stats.PostCountByType[info.Type] = info.Count
+		stats.PostCount += info.Count
+	}
+
+	if withFollowers {
+		var followerCount int64
+		if err := database.C.Model(&models.Subscription{}).
+			Where("account_id = ?", publisher.ID).
+			Count(&followerCount).Error; err != nil {
+			return stats, err
+		}
+		stats.FollowerCount = &followerCount
+	}
+
+	postIdx := postTx().Select("id")
+
+	if err := FilterPostDraft(database.C.Model(&models.Post{})).
+		Where("reply_id IN (?)", postIdx).
+		Count(&stats.ReplyCount).Error; err != nil {
+		return stats, err
+	}
+
+	var err error
+	if stats.ReactionList, err = ListReactions(database.C.Where("post_id IN (?)", postIdx)); err != nil {
+		return stats, err
+	}
+	for _, count := range stats.ReactionList {
+		stats.ReactionCount += count
+	}
+
+	since := time.Now().AddDate(0, 0, -days+1).Truncate(24 * time.Hour)
+
+	var postSeries []struct {
+		Date  time.Time
+		Count int64
+	}
+	if err := postTx().
+		Select("DATE(published_at) as date, COUNT(id) as count").
+		Where("published_at >= ?", since).
+		Group("date").
+		Scan(&postSeries).Error; err != nil {
+		return stats, err
+	}
+
+	var followerSeries []struct {
+		Date  time.Time
+		Count int64
+	}
+	if withFollowers {
+		if err := database.C.Model(&models.Subscription{}).
+			Select("DATE(created_at) as date, COUNT(id) as count").
+			Where("account_id = ? AND created_at >= ?", publisher.ID, since).
+			Group("date").
+			Scan(&followerSeries).Error; err != nil {
+			return stats, err
+		}
+	}
+
+	postMapping := make(map[string]int64, len(postSeries))
+	for _, info := range postSeries {
+		postMapping[info.Date.Format(statsSeriesDateLayout)] = info.Count
+	}
+	followerMapping := make(map[string]int64, len(followerSeries))
+	for _, info := range followerSeries {
+		followerMapping[info.Date.Format(statsSeriesDateLayout)] = info.Count
+	}
+
+	// Fill the days without any activity, so the series are continuous
+	stats.PostSeries = make([]models.StatsSeriesPoint, 0, days)
+	if withFollowers {
+		stats.FollowerSeries = make([]models.StatsSeriesPoint, 0, days)
+	}
+	for idx := 0; idx < days; idx++ {
+		date := since.AddDate(0, 0, idx).Format(statsSeriesDateLayout)
+		stats.PostSeries = append(stats.PostSeries, models.StatsSeriesPoint{Date: date, Count: postMapping[date]})
+		if withFollowers {
+			stats.FollowerSeries = append(stats.FollowerSeries, models.StatsSeriesPoint{Date: date, Count: followerMapping[date]})
+		}
+	}
+
+	return stats, nil
+}
+
+// RecalculatePostVoteCount will recompute the vote totals of every post from the reactions table.
+func RecalculatePostVoteCount() {
+	log.Debug().Msg("Now recalculating post vote counts...")
+
+	tx := database.C.Exec(`
+		UPDATE posts SET
+			total_upvote = (
+				SELECT COUNT(r.id) FROM reactions r
+				WHERE r.post_id = posts.id AND r.attitude = ?
+			),
+			total_downvote = (
+				SELECT COUNT(r.id) FROM reactions r
+				WHERE r.post_id = posts.id AND r.attitude = ?
+			)
+		WHERE deleted_at IS NULL
+	`, models.AttitudePositive, models.AttitudeNegative)
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("An error occurred when recalculating post vote counts...")
+		return
+	}
+
+	log.Debug().Int64("affected", tx.RowsAffected).Msg("Recalculate post vote counts accomplished.")
+}
+
+// RecalculatePublisherVoteCount will recompute the vote totals of every publisher from the reactions table.
+// The totals are maintained incrementally by ModifyPosterVoteCount, this job is used to correct the drift.
+func RecalculatePublisherVoteCount() {
+	log.Debug().Msg("Now recalculating publisher vote counts...")
+
+	tx := database.C.Exec(`
+		UPDATE publishers SET
+			total_upvote = (
+				SELECT COUNT(r.id)
+				FROM reactions r
+				JOIN posts p ON p.id = r.post_id
+				WHERE p.publisher_id = publishers.id AND p.deleted_at IS NULL AND r.attitude = ?
+			),
+			total_downvote = (
+				SELECT COUNT(r.id)
+				FROM reactions r
+				JOIN posts p ON p.id = r.post_id
+				WHERE p.publisher_id = publishers.id AND p.deleted_at IS NULL AND r.attitude = ?
+			)
+		WHERE deleted_at IS NULL
+	`, models.AttitudePositive, models.AttitudeNegative)
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("An error occurred when recalculating publisher vote counts...")
+		return
+	}
+
+	log.Debug().Int64("affected", tx.RowsAffected).Msg("Recalculate publisher vote counts accomplished.")
+}
+
+// RecalculatePublisherFollowerCount will recompute the denormalized follower count of every publisher.
+func RecalculatePublisherFollowerCount() {
+	log.Debug().Msg("Now recalculating publisher follower counts...")
+
+	tx := database.C.Exec(`
+		UPDATE publishers SET
+			total_follower = (
+				SELECT COUNT(s.id)
+				FROM subscriptions s
+				WHERE s.account_id = publishers.id AND s.deleted_at IS NULL
+			)
+		WHERE deleted_at IS NULL
+	`)
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("An error occurred when recalculating publisher follower counts...")
+		return
+	}
+
+	log.Debug().Int64("affected", tx.RowsAffected).Msg("Recalculate publisher follower counts accomplished.")
+}
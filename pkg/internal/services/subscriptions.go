@@ -3,6 +3,9 @@ package services
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
@@ -13,6 +16,7 @@ import (
 	"git.solsynth.dev/hypernet/pusher/pkg/pushkit"
 	"github.com/samber/lo"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetSubscriptionOnUser(user authm.Account, target models.Publisher) (*models.Subscription, error) {
@@ -48,12 +52,34 @@ func GetSubscriptionOnCategory(user authm.Account, target models.Category) (*mod
 	return &subscription, nil
 }
 
-func SubscribeToUser(user authm.Account, target models.Publisher) (models.Subscription, error) {
+func GetSubscriptionOnSeries(user authm.Account, target models.Series) (*models.Subscription, error) {
 	var subscription models.Subscription
-	if err := database.C.Where("follower_id = ? AND account_id = ?", user.ID, target.ID).First(&subscription).Error; err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return subscription, fmt.Errorf("subscription already exists")
+	if err := database.C.Where("follower_id = ? AND series_id = ?", user.ID, target.ID).First(&subscription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("unable to get subscription: %v", err)
+	}
+	return &subscription, nil
+}
+
+// createSubscription will insert the subscription, the unique indexes reject the one subscribed concurrently.
+func createSubscription(tx *gorm.DB, subscription *models.Subscription) error {
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(subscription)
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected != 1 {
+		return fmt.Errorf("subscription already exists")
+	}
+	return nil
+}
+
+func SubscribeToUser(user authm.Account, target models.Publisher) (models.Subscription, error) {
+	var subscription models.Subscription
+	if err := database.C.Where("follower_id = ? AND account_id = ?", user.ID, target.ID).First(&subscription).Error; err == nil {
+		return subscription, fmt.Errorf("subscription already exists")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return subscription, fmt.Errorf("unable to check subscription is exists or not: %v", err)
 	}
 
 	subscription = models.Subscription{
@@ -61,16 +87,21 @@ func SubscribeToUser(user authm.Account, target models.Publisher) (models.Subscr
 		AccountID:  &target.ID,
 	}
 
-	err := database.C.Save(&subscription).Error
+	err := database.C.Transaction(func(tx *gorm.DB) error {
+		if err := createSubscription(tx, &subscription); err != nil {
+			return err
+		}
+		return ModifyPublisherFollowerCount(tx, target, 1)
+	})
 	return subscription, err
 }
 
 func SubscribeToTag(user authm.Account, target models.Tag) (models.Subscription, error) {
 	var subscription models.Subscription
-	if err := database.C.Where("follower_id = ? AND tag_id = ?", user.ID, target.ID).First(&subscription).Error; err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return subscription, fmt.Errorf("subscription already exists")
-		}
+	if err := database.C.Where("follower_id = ? AND tag_id = ?", user.ID, target.ID).First(&subscription).Error; err == nil {
+		return subscription, fmt.Errorf("subscription already exists")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return subscription, fmt.Errorf("unable to check subscription is exists or not: %v", err)
 	}
 
 	subscription = models.Subscription{
@@ -78,16 +109,16 @@ func SubscribeToTag(user authm.Account, target models.Tag) (models.Subscription,
 		TagID:      &target.ID,
 	}
 
-	err := database.C.Save(&subscription).Error
+	err := createSubscription(database.C, &subscription)
 	return subscription, err
 }
 
 func SubscribeToCategory(user authm.Account, target models.Category) (models.Subscription, error) {
 	var subscription models.Subscription
-	if err := database.C.Where("follower_id = ? AND category_id = ?", user.ID, target.ID).First(&subscription).Error; err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return subscription, fmt.Errorf("subscription already exists")
-		}
+	if err := database.C.Where("follower_id = ? AND category_id = ?", user.ID, target.ID).First(&subscription).Error; err == nil {
+		return subscription, fmt.Errorf("subscription already exists")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return subscription, fmt.Errorf("unable to check subscription is exists or not: %v", err)
 	}
 
 	subscription = models.Subscription{
@@ -95,7 +126,24 @@ func SubscribeToCategory(user authm.Account, target models.Category) (models.Sub
 		CategoryID: &target.ID,
 	}
 
-	err := database.C.Save(&subscription).Error
+	err := createSubscription(database.C, &subscription)
+	return subscription, err
+}
+
+func SubscribeToSeries(user authm.Account, target models.Series) (models.Subscription, error) {
+	var subscription models.Subscription
+	if err := database.C.Where("follower_id = ? AND series_id = ?", user.ID, target.ID).First(&subscription).Error; err == nil {
+		return subscription, fmt.Errorf("subscription already exists")
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return subscription, fmt.Errorf("unable to check subscription is exists or not: %v", err)
+	}
+
+	subscription = models.Subscription{
+		FollowerID: user.ID,
+		SeriesID:   &target.ID,
+	}
+
+	err := createSubscription(database.C, &subscription)
 	return subscription, err
 }
 
@@ -108,7 +156,12 @@ func UnsubscribeFromUser(user authm.Account, target models.Publisher) error {
 		return fmt.Errorf("unable to check subscription is exists or not: %v", err)
 	}
 
-	err := database.C.Delete(&subscription).Error
+	err := database.C.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&subscription).Error; err != nil {
+			return err
+		}
+		return ModifyPublisherFollowerCount(tx, target, -1)
+	})
 	return err
 }
 
@@ -138,166 +191,271 @@ func UnsubscribeFromCategory(user authm.Account, target models.Category) error {
 	return err
 }
 
-func NotifyUserSubscription(poster models.Publisher, item models.Post, content string, title *string) error {
-	if item.Visibility == models.PostVisibilityNone {
-		return nil
-	}
-
-	var subscriptions []models.Subscription
-	if err := database.C.Where("account_id = ?", poster.ID).Preload("Follower").Find(&subscriptions).Error; err != nil {
-		return fmt.Errorf("unable to get subscriptions: %v", err)
+func UnsubscribeFromSeries(user authm.Account, target models.Series) error {
+	var subscription models.Subscription
+	if err := database.C.Where("follower_id = ? AND series_id = ?", user.ID, target.ID).First(&subscription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("subscription does not exist")
+		}
+		return fmt.Errorf("unable to check subscription is exists or not: %v", err)
 	}
 
-	nTitle := fmt.Sprintf("New post from %s (%s)", poster.Nick, poster.Name)
-	nSubtitle := "From your subscription"
+	err := database.C.Delete(&subscription).Error
+	return err
+}
 
-	body := TruncatePostContentShort(content)
-	if title != nil {
-		body = fmt.Sprintf("%s\n%s", *title, body)
+func FilterSubscriptionWithKind(tx *gorm.DB, kind string) (*gorm.DB, error) {
+	switch kind {
+	case "":
+		return tx, nil
+	case "users":
+		return tx.Where("account_id IS NOT NULL"), nil
+	case "tags":
+		return tx.Where("tag_id IS NOT NULL"), nil
+	case "categories":
+		return tx.Where("category_id IS NOT NULL"), nil
+	case "series":
+		return tx.Where("series_id IS NOT NULL"), nil
+	default:
+		return tx, fmt.Errorf("unknown subscription kind: %s", kind)
 	}
+}
 
-	userIDs := make([]uint64, 0, len(subscriptions))
-	for _, subscription := range subscriptions {
-		userIDs = append(userIDs, uint64(subscription.Follower.ID))
+func CountSubscription(tx *gorm.DB) (int64, error) {
+	var count int64
+	if err := tx.Model(&models.Subscription{}).Count(&count).Error; err != nil {
+		return count, err
 	}
 
-	if item.Visibility == models.PostVisibilitySelected {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return lo.Contains(item.VisibleUsers, uint(entry))
-		})
-	} else if item.Visibility == models.PostVisibilityFiltered {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(item.InvisibleUsers, uint(entry))
-		})
-	} else if invisibleList := ListPostInvisibleUser(poster, item.Visibility); len(invisibleList) > 0 {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(invisibleList, uint(entry))
-		})
+	return count, nil
+}
+
+func ListSubscription(tx *gorm.DB, take int, offset int) ([]models.Subscription, error) {
+	if take > 100 {
+		take = 100
 	}
 
-	err := authkit.NotifyUserBatch(gap.Nx, userIDs, pushkit.Notification{
-		Topic:    "interactive.subscription",
-		Title:    nTitle,
-		Subtitle: nSubtitle,
-		Body:     body,
-		Priority: 3,
-	})
+	var subscriptions []models.Subscription
+	if err := tx.
+		Preload("Account").
+		Preload("Tag").
+		Preload("Category").
+		Preload("Series").
+		Limit(take).Offset(offset).
+		Order("created_at DESC").
+		Find(&subscriptions).Error; err != nil {
+		return subscriptions, err
+	}
 
-	return err
+	return subscriptions, nil
 }
 
-func NotifyTagSubscription(poster models.Tag, og models.Publisher, item models.Post, content string, title *string) error {
-	if item.Visibility == models.PostVisibilityNone {
-		return nil
+// ListPublisherFollower will return the account id of the users who subscribed the publisher.
+func ListPublisherFollower(target models.Publisher, take int, offset int) ([]uint, error) {
+	if take > 100 {
+		take = 100
 	}
 
-	var subscriptions []models.Subscription
-	if err := database.C.Where("tag_id = ?", poster.ID).Preload("Follower").Find(&subscriptions).Error; err != nil {
-		return fmt.Errorf("unable to get subscriptions: %v", err)
+	var followers []uint
+	if err := database.C.Model(&models.Subscription{}).
+		Where("account_id = ?", target.ID).
+		Limit(take).Offset(offset).
+		Order("created_at DESC").
+		Pluck("follower_id", &followers).Error; err != nil {
+		return followers, err
 	}
 
-	nTitle := fmt.Sprintf("New post in %s by %s (%s)", poster.Name, og.Nick, og.Name)
-	nSubtitle := "From your subscription"
+	return followers, nil
+}
 
-	body := TruncatePostContentShort(content)
-	if title != nil {
-		body = fmt.Sprintf("%s\n%s", *title, body)
+func GetSubscription(user authm.Account, id uint) (models.Subscription, error) {
+	var subscription models.Subscription
+	if err := database.C.Where("id = ? AND follower_id = ?", id, user.ID).First(&subscription).Error; err != nil {
+		return subscription, fmt.Errorf("unable to get subscription: %v", err)
 	}
+	return subscription, nil
+}
 
-	userIDs := make([]uint64, 0, len(subscriptions))
-	for _, subscription := range subscriptions {
-		userIDs = append(userIDs, uint64(subscription.Follower.ID))
+func EditSubscription(subscription models.Subscription, mode models.SubscriptionMode, interval models.SubscriptionDigestInterval, priority int) (models.Subscription, error) {
+	if subscription.Mode != models.SubscriptionModeDigest && mode == models.SubscriptionModeDigest {
+		// Start counting the digest period from now, or the first digest will contain all the history posts
+		subscription.LastDigestAt = lo.ToPtr(time.Now())
 	}
 
-	if item.Visibility == models.PostVisibilitySelected {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return lo.Contains(item.VisibleUsers, uint(entry))
-		})
-	} else if item.Visibility == models.PostVisibilityFiltered {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(item.InvisibleUsers, uint(entry))
-		})
-	} else if invisibleList := ListPostInvisibleUser(item.Publisher, item.Visibility); len(invisibleList) > 0 {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(invisibleList, uint(entry))
-		})
-	}
+	subscription.Mode = mode
+	subscription.DigestInterval = interval
+	subscription.Priority = priority
 
-	err := authkit.NotifyUserBatch(gap.Nx, userIDs, pushkit.Notification{
-		Topic:    "interactive.subscription",
-		Title:    nTitle,
-		Subtitle: nSubtitle,
-		Body:     body,
-		Priority: 3,
-	})
+	err := database.C.Save(&subscription).Error
+	return subscription, err
+}
 
-	return err
+type subscriptionReason struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
 }
 
-func NotifyCategorySubscription(poster models.Category, og models.Publisher, item models.Post, content string, title *string) error {
+// ListPostSubscriptionNotification will build the notifications for the followers of the publisher, tags, categories and series of the post.
+// The recipients are gathered across all kinds of subscriptions and deduplicated,
+// every follower will only receive one notification which lists the subscriptions they received it from.
+func ListPostSubscriptionNotification(poster models.Publisher, item models.Post, content string, title *string) ([]PendingNotification, error) {
 	if item.Visibility == models.PostVisibilityNone {
-		return nil
+		return nil, nil
+	}
+
+	tagIdx := lo.Map(item.Tags, func(item models.Tag, index int) uint {
+		return item.ID
+	})
+	categoryIdx := lo.Map(item.Categories, func(item models.Category, index int) uint {
+		return item.ID
+	})
+
+	targets := database.C.Where("account_id = ?", poster.ID).
+		Or("tag_id IN ?", tagIdx).
+		Or("category_id IN ?", categoryIdx)
+	if item.SeriesID != nil {
+		targets = targets.Or("series_id = ?", *item.SeriesID)
 	}
 
 	var subscriptions []models.Subscription
-	if err := database.C.Where("category_id = ?", poster.ID).Preload("Follower").Find(&subscriptions).Error; err != nil {
-		return fmt.Errorf("unable to get subscriptions: %v", err)
+	if err := database.C.
+		Where("mode = ?", models.SubscriptionModeInstant).
+		Where(targets).
+		Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("unable to get subscriptions: %v", err)
 	}
 
-	nTitle := fmt.Sprintf("New post in %s by %s (%s)", poster.Name, og.Nick, og.Name)
-	nSubtitle := "From your subscription"
+	tags := lo.SliceToMap(item.Tags, func(item models.Tag) (uint, models.Tag) {
+		return item.ID, item
+	})
+	categories := lo.SliceToMap(item.Categories, func(item models.Category) (uint, models.Category) {
+		return item.ID, item
+	})
 
-	body := TruncatePostContentShort(content)
-	if title != nil {
-		body = fmt.Sprintf("%s\n%s", *title, body)
+	type recipientState struct {
+		Priority int
+		Reasons  []subscriptionReason
 	}
 
-	userIDs := make([]uint64, 0, len(subscriptions))
+	recipients := make(map[uint64]*recipientState)
 	for _, subscription := range subscriptions {
-		userIDs = append(userIDs, uint64(subscription.Follower.ID))
+		var reason subscriptionReason
+		switch {
+		case subscription.AccountID != nil:
+			reason = subscriptionReason{Type: "publisher", ID: poster.ID, Name: poster.Nick}
+		case subscription.TagID != nil:
+			reason = subscriptionReason{Type: "tag", ID: *subscription.TagID, Name: tags[*subscription.TagID].Name}
+		case subscription.CategoryID != nil:
+			reason = subscriptionReason{Type: "category", ID: *subscription.CategoryID, Name: categories[*subscription.CategoryID].Name}
+		case subscription.SeriesID != nil && item.Series != nil:
+			reason = subscriptionReason{Type: "series", ID: *subscription.SeriesID, Name: item.Series.Title}
+		default:
+			continue
+		}
+
+		follower := uint64(subscription.FollowerID)
+		if _, ok := recipients[follower]; !ok {
+			recipients[follower] = &recipientState{}
+		}
+		state := recipients[follower]
+		state.Priority = max(state.Priority, subscription.Priority)
+		state.Reasons = append(state.Reasons, reason)
 	}
 
-	if item.Visibility == models.PostVisibilitySelected {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return lo.Contains(item.VisibleUsers, uint(entry))
-		})
-	} else if item.Visibility == models.PostVisibilityFiltered {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(item.InvisibleUsers, uint(entry))
+	userIDs := FilterPostNotifiableUser(item, lo.Keys(recipients))
+
+	// Followers received the notification with the same reasons and priority will be sent in one batch
+	type recipientGroup struct {
+		Priority int
+		Reasons  []subscriptionReason
+		UserIDs  []uint64
+	}
+
+	groups := make(map[string]*recipientGroup)
+	for _, follower := range userIDs {
+		state := recipients[follower]
+		sort.Slice(state.Reasons, func(i, j int) bool {
+			if state.Reasons[i].Type != state.Reasons[j].Type {
+				return state.Reasons[i].Type > state.Reasons[j].Type
+			}
+			return state.Reasons[i].ID < state.Reasons[j].ID
 		})
-	} else if invisibleList := ListPostInvisibleUser(item.Publisher, item.Visibility); len(invisibleList) > 0 {
-		userIDs = lo.Filter(userIDs, func(entry uint64, index int) bool {
-			return !lo.Contains(invisibleList, uint(entry))
+		key := fmt.Sprintf("%d#%v", state.Priority, state.Reasons)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &recipientGroup{Priority: state.Priority, Reasons: state.Reasons}
+		}
+		groups[key].UserIDs = append(groups[key].UserIDs, follower)
+	}
+
+	body := TruncatePostContentShort(content)
+	if title != nil {
+		body = fmt.Sprintf("%s\n%s", *title, body)
+	}
+
+	pending := make([]PendingNotification, 0, len(groups))
+	for _, group := range groups {
+		pending = append(pending, PendingNotification{
+			UserIDs: group.UserIDs,
+			Notification: pushkit.Notification{
+				Topic:    "interactive.subscription",
+				Title:    fmt.Sprintf("New post from %s (%s)", poster.Nick, poster.Name),
+				Subtitle: RenderSubscriptionReason(group.Reasons),
+				Body:     body,
+				Priority: group.Priority,
+				Metadata: map[string]any{
+					"reasons": group.Reasons,
+				},
+			},
 		})
 	}
 
-	err := authkit.NotifyUserBatch(gap.Nx, userIDs, pushkit.Notification{
-		Topic:    "interactive.subscription",
-		Title:    nTitle,
-		Subtitle: nSubtitle,
-		Body:     body,
-		Priority: 3,
-	})
+	return pending, nil
+}
 
-	return err
+func RenderSubscriptionReason(reasons []subscriptionReason) string {
+	names := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		switch reason.Type {
+		case "tag":
+			names = append(names, "#"+reason.Name)
+		case "category":
+			names = append(names, "category "+reason.Name)
+		case "series":
+			names = append(names, "series "+reason.Name)
+		default:
+			names = append(names, reason.Name)
+		}
+	}
+	return "From your subscription to " + strings.Join(names, ", ")
 }
 
-// ListPostInvisibleUser will return a list of users which should not be notified the post.
-// NOTICE If the visibility is PostVisibilitySelected, PostVisibilityFiltered or PostVisibilityNone, you need do extra steps to filter users
+// FilterPostNotifiableUser will remove the users who cannot see the post from the list.
 // WARNING This function won't use cache, be careful of the queries
-func ListPostInvisibleUser(og models.Publisher, visibility models.PostVisibilityLevel) []uint {
-	switch visibility {
+func FilterPostNotifiableUser(item models.Post, userIDs []uint64) []uint64 {
+	switch item.Visibility {
 	case models.PostVisibilityAll:
-		return []uint{}
+		return userIDs
 	case models.PostVisibilityFriends:
-		if og.AccountID == nil {
-			return []uint{}
+		if item.Publisher.AccountID == nil {
+			return []uint64{}
 		}
-		userFriends, _ := authkit.ListRelative(gap.Nx, *og.AccountID, int32(authm.RelationshipFriend), true)
-		return lo.Map(userFriends, func(item *proto.UserInfo, index int) uint {
-			return uint(item.GetId())
+		userFriends, _ := authkit.ListRelative(gap.Nx, *item.Publisher.AccountID, int32(authm.RelationshipFriend), true)
+		friendList := lo.Map(userFriends, func(item *proto.UserInfo, index int) uint64 {
+			return item.GetId()
+		})
+		return lo.Filter(userIDs, func(entry uint64, index int) bool {
+			return lo.Contains(friendList, entry)
+		})
+	case models.PostVisibilitySelected:
+		return lo.Filter(userIDs, func(entry uint64, index int) bool {
+			return lo.Contains(item.VisibleUsers, uint(entry))
+		})
+	case models.PostVisibilityFiltered:
+		return lo.Filter(userIDs, func(entry uint64, index int) bool {
+			return !lo.Contains(item.InvisibleUsers, uint(entry))
 		})
 	default:
-		return nil
+		return []uint64{}
 	}
 }
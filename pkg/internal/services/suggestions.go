@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/samber/lo"
+)
+
+const (
+	SuggestionTimeout       = 20 * time.Second
+	SuggestionTagsMax       = 10
+	SuggestionCategoriesMax = 3
+	SuggestionSourceMax     = 8192
+)
+
+const (
+	SuggestionSourceAI    = "ai"
+	SuggestionSourceLocal = "local"
+)
+
+var ErrSuggestionEmptyDraft = errors.New("the draft has no content to suggest from")
+
+// SuggestedTag is a tag suggested for the post, Existing means the alias is already used by other posts.
+type SuggestedTag struct {
+	Alias    string  `json:"alias"`
+	Name     string  `json:"name"`
+	Score    float64 `json:"score"`
+	Existing bool    `json:"existing"`
+}
+
+// SuggestedCategory is a category suggested for the post, it is always one of the existing categories.
+type SuggestedCategory struct {
+	Alias string  `json:"alias"`
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// PostMetadataSuggestion is the suggested tags and categories of a draft, the Source tells which way produced it.
+type PostMetadataSuggestion struct {
+	Tags       []SuggestedTag      `json:"tags"`
+	Categories []SuggestedCategory `json:"categories"`
+	Source     string              `json:"source"`
+}
+
+var suggestionStopwords = lo.SliceToMap(strings.Fields(`
+	a about above after again against all am an and any are as at be because been before being below between both
+	but by can could did do does doing down during each few for from further had has have having he her here hers
+	him his how i if in into is it its itself just me more most my no nor not now of off on once only or other our
+	ours out over own same she should so some such than that the their theirs them then there these they this those
+	through to too under until up very was we were what when where which while who whom why will with would you
+	your yours also get got like one two use used using via http https www com
+`), func(word string) (string, struct{}) { return word, struct{}{} })
+
+// NormalizeTagAlias will convert the tag name into the alias format, lower case words joined by hyphens.
+func NormalizeTagAlias(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, "-")
+}
+
+func isSuggestionCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// tokenizeSuggestionText will split the text into lower case terms without stopwords.
+// The CJK text has no spaces between words, it was split into overlapping bigrams.
+func tokenizeSuggestionText(content string) []string {
+	var terms []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if term := string(word); len(word) >= 2 && !lo.HasKey(suggestionStopwords, term) {
+			terms = append(terms, term)
+		}
+		word = word[:0]
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			terms = append(terms, string(cjk))
+		}
+		for idx := 0; idx+1 < len(cjk); idx++ {
+			terms = append(terms, string(cjk[idx:idx+2]))
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range strings.ToLower(content) {
+		switch {
+		case isSuggestionCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+
+	return terms
+}
+
+func getSuggestionSource(title, description, content string) string {
+	source := RenderMarkdownPlainText(strings.Join([]string{title, description, content}, "\n\n"))
+	if runes := []rune(source); len(runes) > SuggestionSourceMax {
+		source = string(runes[:SuggestionSourceMax])
+	}
+	return strings.TrimSpace(source)
+}
+
+// getTagPostCounts will return the count of the posts using the tags, keyed by the tag id.
+func getTagPostCounts(tags []models.Tag) map[uint]int64 {
+	if len(tags) == 0 {
+		return make(map[uint]int64)
+	}
+
+	var rows []struct {
+		TagID uint
+		Count int64
+	}
+	database.C.Table("post_tags").
+		Select("tag_id, COUNT(*) AS count").
+		Where("tag_id IN ?", lo.Map(tags, func(item models.Tag, _ int) uint { return item.ID })).
+		Group("tag_id").
+		Scan(&rows)
+	return lo.SliceToMap(rows, func(item struct {
+		TagID uint
+		Count int64
+	}) (uint, int64) {
+		return item.TagID, item.Count
+	})
+}
+
+func sortSuggestedTags(tags []SuggestedTag) []SuggestedTag {
+	if tags == nil {
+		return []SuggestedTag{}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].Score != tags[j].Score {
+			return tags[i].Score > tags[j].Score
+		}
+		return tags[i].Alias < tags[j].Alias
+	})
+	return lo.Slice(tags, 0, SuggestionTagsMax)
+}
+
+func sortSuggestedCategories(categories []SuggestedCategory) []SuggestedCategory {
+	if categories == nil {
+		return []SuggestedCategory{}
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].Score != categories[j].Score {
+			return categories[i].Score > categories[j].Score
+		}
+		return categories[i].Alias < categories[j].Alias
+	})
+	return lo.Slice(categories, 0, SuggestionCategoriesMax)
+}
+
+// SuggestPostMetadata will suggest the tags and categories of the draft.
+// The ai service is asked first, the local keyword ranking is used when the ai service is unavailable,
+// or the user has used up the insight rate limit. Asking the ai service counts against the rate limit.
+func SuggestPostMetadata(title, description, content string, user uint) (PostMetadataSuggestion, error) {
+	source := getSuggestionSource(title, description, content)
+	if len(source) == 0 {
+		return PostMetadataSuggestion{}, ErrSuggestionEmptyDraft
+	}
+
+	var categories []models.Category
+	if err := database.C.Order("alias ASC").Find(&categories).Error; err != nil {
+		return PostMetadataSuggestion{}, err
+	}
+
+	if err := ConsumeInsightRateLimit(user, "suggestion"); err == nil {
+		suggestion, err := suggestPostMetadataWithAI(source, categories, user)
+		if err == nil {
+			return suggestion, nil
+		}
+		log.Warn().Err(err).Msg("Unable to suggest post metadata with ai, falling back to local ranking...")
+	} else if !errors.Is(err, ErrInsightRateLimited) {
+		log.Warn().Err(err).Msg("Unable to check the insight rate limit, falling back to local ranking...")
+	}
+
+	return suggestPostMetadataLocally(source, categories)
+}
+
+func suggestPostMetadataWithAI(source string, categories []models.Category, user uint) (PostMetadataSuggestion, error) {
+	categoryList := lo.Map(categories, func(item models.Category, _ int) string {
+		return fmt.Sprintf("- %s: %s", item.Alias, item.Name)
+	})
+	prompt := fmt.Sprintf(
+		"Suggest up to %d short tags and up to %d categories for the following post. "+
+			"The categories must be chosen from the list by their alias. "+
+			"Reply with JSON only, in the form {\"tags\": [\"tag\"], \"categories\": [\"alias\"]}, the most relevant first.\n\n"+
+			"Categories:\n%s\n\nPost:\n%s",
+		SuggestionTagsMax, SuggestionCategoriesMax, strings.Join(categoryList, "\n"), source,
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), SuggestionTimeout)
+	defer cancel()
+	response, err := DefaultInsightGenerator.Generate(ctx, prompt, user, func(string) {})
+	if err != nil {
+		return PostMetadataSuggestion{}, err
+	}
+
+	start, end := strings.Index(response, "{"), strings.LastIndex(response, "}")
+	if start < 0 || end <= start {
+		return PostMetadataSuggestion{}, fmt.Errorf("ai response is not a json object")
+	}
+	var data struct {
+		Tags       []string `json:"tags"`
+		Categories []string `json:"categories"`
+	}
+	if err := json.Unmarshal([]byte(response[start:end+1]), &data); err != nil {
+		return PostMetadataSuggestion{}, fmt.Errorf("unable to parse ai response: %v", err)
+	}
+
+	names := make(map[string]string)
+	var aliases []string
+	for _, name := range data.Tags {
+		alias := NormalizeTagAlias(name)
+		if len(alias) == 0 || lo.Contains(aliases, alias) {
+			continue
+		}
+		aliases = append(aliases, alias)
+		names[alias] = strings.TrimSpace(name)
+	}
+
+	// The suggested tags were ranked against the existing ones, so the posts tend to share the same tags
+	var existing []models.Tag
+	if len(aliases) > 0 {
+		database.C.Where("alias IN ?", aliases).Find(&existing)
+	}
+	counts := getTagPostCounts(existing)
+	existingMap := lo.SliceToMap(existing, func(item models.Tag) (string, models.Tag) { return item.Alias, item })
+
+	tags := make([]SuggestedTag, 0, len(aliases))
+	for idx, alias := range aliases {
+		tag := SuggestedTag{
+			Alias: alias,
+			Name:  names[alias],
+			Score: float64(len(aliases)-idx) / float64(len(aliases)),
+		}
+		if item, ok := existingMap[alias]; ok {
+			tag.Name = lo.CoalesceOrEmpty(item.Name, tag.Name)
+			tag.Existing = true
+			tag.Score += 0.5 + math.Log10(1+float64(counts[item.ID]))/10
+		}
+		tags = append(tags, tag)
+	}
+
+	categoryMap := lo.SliceToMap(categories, func(item models.Category) (string, models.Category) { return item.Alias, item })
+	var suggestedCategories []SuggestedCategory
+	for idx, alias := range data.Categories {
+		alias = strings.ToLower(strings.TrimSpace(alias))
+		item, ok := categoryMap[alias]
+		if !ok || lo.ContainsBy(suggestedCategories, func(v SuggestedCategory) bool { return v.Alias == alias }) {
+			continue
+		}
+		suggestedCategories = append(suggestedCategories, SuggestedCategory{
+			Alias: item.Alias,
+			Name:  item.Name,
+			Score: float64(len(data.Categories)-idx) / float64(len(data.Categories)),
+		})
+	}
+
+	return PostMetadataSuggestion{
+		Tags:       sortSuggestedTags(tags),
+		Categories: sortSuggestedCategories(suggestedCategories),
+		Source:     SuggestionSourceAI,
+	}, nil
+}
+
+// localSuggestionCorpus is the statistics of the existing posts used by the local ranking.
+type localSuggestionCorpus struct {
+	Total     int64
+	Tags      []models.Tag
+	TagCounts map[uint]int64
+	// Cooccurrence is the count of the posts using both the tag and the category, keyed by the tag id then the category id
+	Cooccurrence map[uint]map[uint]int64
+}
+
+// loadLocalSuggestionCorpus will load the existing tags that might match the terms, and the statistics of them.
+func loadLocalSuggestionCorpus(terms []string) localSuggestionCorpus {
+	candidates := lo.Uniq(terms)
+	sort.Strings(candidates)
+
+	var corpus localSuggestionCorpus
+	database.C.Model(&models.Post{}).Count(&corpus.Total)
+	database.C.Where("alias IN ?", candidates).
+		Or("alias LIKE ? AND split_part(alias, '-', 1) IN ?", "%-%", candidates).
+		Find(&corpus.Tags)
+	corpus.TagCounts = getTagPostCounts(corpus.Tags)
+
+	corpus.Cooccurrence = make(map[uint]map[uint]int64)
+	if len(corpus.Tags) == 0 {
+		return corpus
+	}
+	var rows []struct {
+		TagID      uint
+		CategoryID uint
+		Count      int64
+	}
+	database.C.Table("post_tags").
+		Select("post_tags.tag_id, post_categories.category_id, COUNT(*) AS count").
+		Joins("JOIN post_categories ON post_categories.post_id = post_tags.post_id").
+		Where("post_tags.tag_id IN ?", lo.Map(corpus.Tags, func(item models.Tag, _ int) uint { return item.ID })).
+		Group("post_tags.tag_id, post_categories.category_id").
+		Scan(&rows)
+	for _, row := range rows {
+		if corpus.Cooccurrence[row.TagID] == nil {
+			corpus.Cooccurrence[row.TagID] = make(map[uint]int64)
+		}
+		corpus.Cooccurrence[row.TagID][row.CategoryID] = row.Count
+	}
+	return corpus
+}
+
+// suggestPostMetadataLocally will rank the existing tags and keywords of the draft by TF-IDF.
+func suggestPostMetadataLocally(source string, categories []models.Category) (PostMetadataSuggestion, error) {
+	terms := tokenizeSuggestionText(source)
+	if len(terms) == 0 {
+		return PostMetadataSuggestion{Tags: []SuggestedTag{}, Categories: []SuggestedCategory{}, Source: SuggestionSourceLocal}, nil
+	}
+	return rankPostMetadataLocally(terms, categories, loadLocalSuggestionCorpus(terms)), nil
+}
+
+// rankPostMetadataLocally will rank the tags and categories with the statistics in the corpus.
+// The document frequency of a tag is the count of the posts using it, so the common tags are less preferred.
+// The categories are ranked by the terms shared with their name and description,
+// and by how often they were used together with the matched tags.
+func rankPostMetadataLocally(terms []string, categories []models.Category, corpus localSuggestionCorpus) PostMetadataSuggestion {
+	frequency := make(map[string]float64)
+	for _, term := range terms {
+		frequency[term]++
+	}
+	for term := range frequency {
+		frequency[term] /= float64(len(terms))
+	}
+
+	idf := func(count int64) float64 {
+		return math.Log(float64(1+corpus.Total)/float64(1+count)) + 1
+	}
+
+	// The multi-word aliases are matched when all of their words appear in the draft
+	termFrequency := func(alias string) float64 {
+		parts := tokenizeSuggestionText(strings.ReplaceAll(alias, "-", " "))
+		if len(parts) == 0 {
+			return 0
+		}
+		score := math.MaxFloat64
+		for _, part := range parts {
+			score = min(score, frequency[part])
+		}
+		return score
+	}
+
+	var tags []SuggestedTag
+	matched := make(map[string]bool)
+	cooccurrence := make(map[uint]int64)
+	var cooccurrenceTotal int64
+	for _, item := range corpus.Tags {
+		tf := termFrequency(item.Alias)
+		if tf <= 0 {
+			continue
+		}
+		matched[item.Alias] = true
+		for category, count := range corpus.Cooccurrence[item.ID] {
+			cooccurrence[category] += count
+			cooccurrenceTotal += count
+		}
+		tags = append(tags, SuggestedTag{
+			Alias:    item.Alias,
+			Name:     item.Name,
+			Score:    tf * idf(corpus.TagCounts[item.ID]),
+			Existing: true,
+		})
+	}
+
+	// The keywords never used as tags have no document frequency, they were weighted lower than the existing tags
+	for term, tf := range frequency {
+		if matched[term] || len([]rune(term)) < 3 && !isSuggestionCJK([]rune(term)[0]) {
+			continue
+		}
+		tags = append(tags, SuggestedTag{
+			Alias: term,
+			Name:  term,
+			Score: tf * idf(0) / 2,
+		})
+	}
+
+	var suggestedCategories []SuggestedCategory
+	for _, item := range categories {
+		var score float64
+		for _, term := range lo.Uniq(tokenizeSuggestionText(item.Alias + " " + item.Name + " " + item.Description)) {
+			score += frequency[term]
+		}
+		if cooccurrenceTotal > 0 {
+			score += float64(cooccurrence[item.ID]) / float64(cooccurrenceTotal)
+		}
+		if score > 0 {
+			suggestedCategories = append(suggestedCategories, SuggestedCategory{
+				Alias: item.Alias,
+				Name:  item.Name,
+				Score: score,
+			})
+		}
+	}
+
+	return PostMetadataSuggestion{
+		Tags:       sortSuggestedTags(tags),
+		Categories: sortSuggestedCategories(suggestedCategories),
+		Source:     SuggestionSourceLocal,
+	}
+}
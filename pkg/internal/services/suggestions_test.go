@@ -0,0 +1,107 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"github.com/samber/lo"
+)
+
+func TestTokenizeSuggestionText(t *testing.T) {
+	for content, expected := range map[string][]string{
+		"The Quick brown fox, and the lazy dog!": {"quick", "brown", "fox", "lazy", "dog"},
+		"a I x v2 release 2024":                  {"v2", "release", "2024"},
+		"你好世界":                                   {"你好", "好世", "世界"},
+		"Go语言, 猫":                                {"go", "语言", "猫"},
+		"한국어 text":                               {"한국", "국어", "text"},
+		"https://www.example.com/about":          {"example"},
+	} {
+		if terms := tokenizeSuggestionText(content); !reflect.DeepEqual(terms, expected) {
+			t.Errorf("expected %q to be tokenized into %q, got %q", content, expected, terms)
+		}
+	}
+
+	if terms := tokenizeSuggestionText("the and of, to!"); len(terms) != 0 {
+		t.Errorf("expected the stopwords to be removed, got %q", terms)
+	}
+}
+
+func TestRankPostMetadataLocally(t *testing.T) {
+	tags := []models.Tag{
+		{Alias: "golang", Name: "Go"},
+		{Alias: "programming", Name: "Programming"},
+		{Alias: "machine-learning", Name: "Machine Learning"},
+		{Alias: "deep-learning", Name: "Deep Learning"},
+	}
+	for idx := range tags {
+		tags[idx].ID = uint(idx + 1)
+	}
+	categories := []models.Category{
+		{Alias: "art", Name: "Art"},
+		{Alias: "life", Name: "Life"},
+		{Alias: "programming", Name: "Programming", Description: "code"},
+	}
+	for idx := range categories {
+		categories[idx].ID = uint(idx + 10)
+	}
+	corpus := localSuggestionCorpus{
+		Total:     100,
+		Tags:      tags,
+		TagCounts: map[uint]int64{1: 2, 2: 50, 3: 5},
+		Cooccurrence: map[uint]map[uint]int64{
+			1: {11: 3, 12: 1},
+			4: {10: 100},
+		},
+	}
+
+	terms := tokenizeSuggestionText("Golang programming, golang tutorial for programming with machine learning")
+	suggestion := rankPostMetadataLocally(terms, categories, corpus)
+
+	if suggestion.Source != SuggestionSourceLocal {
+		t.Errorf("unexpected source %q", suggestion.Source)
+	}
+
+	// The rare tags rank first, the common ones after, and the keywords never used as tags come last
+	aliases := lo.Map(suggestion.Tags, func(item SuggestedTag, _ int) string { return item.Alias })
+	expected := []string{"golang", "machine-learning", "programming", "learning", "machine", "tutorial"}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("expected the tags %q, got %q", expected, aliases)
+	}
+	for _, item := range suggestion.Tags {
+		if existing := lo.Contains(expected[:3], item.Alias); item.Existing != existing {
+			t.Errorf("expected the tag %q existing to be %v", item.Alias, existing)
+		}
+	}
+
+	// The category used with the matched tags ranks above the one only sharing the terms,
+	// the unmatched tags do not count
+	categoryAliases := lo.Map(suggestion.Categories, func(item SuggestedCategory, _ int) string { return item.Alias })
+	if !reflect.DeepEqual(categoryAliases, []string{"life", "programming"}) {
+		t.Errorf("unexpected categories %q", categoryAliases)
+	}
+}
+
+func TestRankPostMetadataLocallyLimits(t *testing.T) {
+	var terms []string
+	for idx := 0; idx < SuggestionTagsMax*2; idx++ {
+		terms = append(terms, "keyword"+string(rune('a'+idx)))
+	}
+	suggestion := rankPostMetadataLocally(terms, nil, localSuggestionCorpus{})
+	if len(suggestion.Tags) != SuggestionTagsMax {
+		t.Errorf("expected %d tags, got %d", SuggestionTagsMax, len(suggestion.Tags))
+	}
+	if suggestion.Tags[0].Alias != "keyworda" {
+		t.Errorf("expected the tied tags to be ordered by alias, got %q", suggestion.Tags[0].Alias)
+	}
+	if suggestion.Categories == nil || len(suggestion.Categories) != 0 {
+		t.Errorf("expected no categories, got %+v", suggestion.Categories)
+	}
+}
+
+func TestSuggestPostMetadataEmptyDraft(t *testing.T) {
+	if _, err := SuggestPostMetadata(" ", "", "<p></p>", 1); !errors.Is(err, ErrSuggestionEmptyDraft) {
+		t.Errorf("expected ErrSuggestionEmptyDraft, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	iproto "git.solsynth.dev/hypernet/insight/pkg/proto"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/database"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/gap"
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// PostTranslator translates the text into the target language, the text may contain markdown.
+type PostTranslator interface {
+	Translate(ctx context.Context, text string, to string, user uint) (string, error)
+}
+
+// InsightTranslator translates with the ai service, the same one generates the post insights.
+type InsightTranslator struct{}
+
+func (v InsightTranslator) Translate(ctx context.Context, text string, to string, user uint) (string, error) {
+	conn, err := gap.Nx.GetClientGrpcConn("ai")
+	if err != nil {
+		return "", fmt.Errorf("failed to connect Insight: %v", err)
+	}
+	ic := iproto.NewInsightServiceClient(conn)
+	resp, err := ic.GenerateInsight(ctx, &iproto.InsightRequest{
+		Source: fmt.Sprintf(
+			"Translate the following text into the language %s. Keep the markdown formatting, reply with the translated text only.\n\n%s",
+			to, text,
+		),
+		UserId: uint64(user),
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Response, nil
+}
+
+// DefaultPostTranslator is the backend used to translate posts, replace it with a stub in tests.
+var DefaultPostTranslator PostTranslator = InsightTranslator{}
+
+// ErrTranslationUnavailable is returned when the translator failed, the request may succeed later.
+var ErrTranslationUnavailable = errors.New("translation service is unavailable")
+
+var translationRegionRegex = regexp.MustCompile(`^([A-Za-z]{2}|[0-9]{3})$`)
+
+// NormalizeTranslationLanguage will check the target language is an ISO 639-1 code with an optional region, such as zh-TW.
+func NormalizeTranslationLanguage(to string) (string, error) {
+	base, region, hasRegion := strings.Cut(strings.TrimSpace(to), "-")
+	base, err := NormalizeLanguageCode(base)
+	if err != nil || base == LanguageUnknown {
+		return to, fmt.Errorf("invalid target language %s", to)
+	}
+	if !hasRegion {
+		return base, nil
+	}
+	if !translationRegionRegex.MatchString(region) {
+		return to, fmt.Errorf("invalid target language %s", to)
+	}
+	return base + "-" + strings.ToUpper(region), nil
+}
+
+// GetPostContentHash will return the hash of the translatable parts of the post body.
+func GetPostContentHash(post models.Post) string {
+	title, _ := post.Body["title"].(string)
+	description, _ := post.Body["description"].(string)
+	content, _ := post.Body["content"].(string)
+
+	hash := sha256.New()
+	for _, part := range []string{title, description, content} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// TranslatePost will translate the post into the target language, the result was cached until the post changed.
+// Translating a post counts against the insight rate limit of the user, the cached translations do not.
+func TranslatePost(post models.Post, to string, user uint) (models.PostTranslation, error) {
+	to, err := NormalizeTranslationLanguage(to)
+	if err != nil {
+		return models.PostTranslation{}, err
+	}
+
+	hash := GetPostContentHash(post)
+
+	var translation models.PostTranslation
+	if err := database.C.Where("post_id = ? AND language = ? AND content_hash = ?", post.ID, to, hash).
+		First(&translation).Error; err == nil {
+		return translation, nil
+	}
+
+	if err := ConsumeInsightRateLimit(user, "translation"); err != nil {
+		return translation, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	translation, err = translatePostBody(ctx, DefaultPostTranslator, post, to, user)
+	if err != nil {
+		return translation, err
+	}
+	translation.ContentHash = hash
+
+	if err := database.C.Clauses(clause.OnConflict{DoNothing: true}).Create(&translation).Error; err != nil {
+		return translation, err
+	}
+
+	return translation, nil
+}
+
+// translatePostBody will translate the title, description and content of the post,
+// the errors of the translator are wrapped with ErrTranslationUnavailable.
+func translatePostBody(ctx context.Context, translator PostTranslator, post models.Post, to string, user uint) (models.PostTranslation, error) {
+	translate := func(key string) (string, error) {
+		val, ok := post.Body[key].(string)
+		if !ok || len(val) == 0 {
+			return "", nil
+		}
+		out, err := translator.Translate(ctx, val, to, user)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrTranslationUnavailable, err)
+		}
+		return out, nil
+	}
+
+	translation := models.PostTranslation{
+		PostID:   post.ID,
+		Language: to,
+	}
+
+	var err error
+	if translation.Title, err = translate("title"); err != nil {
+		return translation, err
+	}
+	if translation.Description, err = translate("description"); err != nil {
+		return translation, err
+	}
+	if translation.Content, err = translate("content"); err != nil {
+		return translation, err
+	}
+
+	return translation, nil
+}
+
+// InvalidatePostTranslations will delete the translations that no longer match the post body.
+// They were deleted permanently, so the unique index will not be blocked when the body was changed back.
+func InvalidatePostTranslations(post models.Post) error {
+	return database.C.Unscoped().
+		Where("post_id = ? AND content_hash <> ?", post.ID, GetPostContentHash(post)).
+		Delete(&models.PostTranslation{}).Error
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"git.solsynth.dev/hypernet/interactive/pkg/internal/models"
+)
+
+type stubTranslator struct {
+	calls []string
+	err   error
+}
+
+func (v *stubTranslator) Translate(ctx context.Context, text string, to string, user uint) (string, error) {
+	v.calls = append(v.calls, text)
+	if v.err != nil {
+		return "", v.err
+	}
+	return "[" + to + "] " + text, nil
+}
+
+func TestTranslatePostBody(t *testing.T) {
+	translator := &stubTranslator{}
+	post := models.Post{Body: map[string]any{
+		"title":   "Hello",
+		"content": "World",
+	}}
+	post.ID = 1
+
+	translation, err := translatePostBody(context.Background(), translator, post, "ja", 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if translation.Title != "[ja] Hello" || translation.Content != "[ja] World" || translation.Description != "" {
+		t.Errorf("unexpected translation %+v", translation)
+	}
+	if translation.PostID != 1 || translation.Language != "ja" {
+		t.Errorf("expected the post and language to be filled, got %+v", translation)
+	}
+	if len(translator.calls) != 2 {
+		t.Errorf("expected the empty description to be skipped, got %d calls", len(translator.calls))
+	}
+}
+
+func TestTranslatePostBodyUnavailable(t *testing.T) {
+	translator := &stubTranslator{err: errors.New("connection refused")}
+	post := models.Post{Body: map[string]any{"content": "World"}}
+
+	if _, err := translatePostBody(context.Background(), translator, post, "ja", 1); !errors.Is(err, ErrTranslationUnavailable) {
+		t.Fatalf("expected ErrTranslationUnavailable, got %v", err)
+	}
+}
+
+func TestNormalizeTranslationLanguage(t *testing.T) {
+	for input, expected := range map[string]string{
+		"ja":     "ja",
+		"ZH-tw":  "zh-TW",
+		"es-419": "es-419",
+	} {
+		if out, err := NormalizeTranslationLanguage(input); err != nil || out != expected {
+			t.Errorf("expected %s to be %s, got %s (%v)", input, expected, out, err)
+		}
+	}
+
+	for _, input := range []string{"", "unknown", "xx", "english", "en-", "en-Latn-US"} {
+		if _, err := NormalizeTranslationLanguage(input); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
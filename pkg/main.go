@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	pkg "git.solsynth.dev/hypernet/interactive/pkg/internal"
 	"git.solsynth.dev/hypernet/interactive/pkg/internal/cache"
@@ -26,7 +27,11 @@ func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 }
 
+var recalculateCounters = flag.Bool("recalculate-counters", false, "Recalculate the vote counters from the reactions and the poll tallies from the answers, and then exit")
+
 func main() {
+	flag.Parse()
+
 	// Booting screen
 	fmt.Println(color.YellowString(" ___       _                      _   _\n|_ _|_ __ | |_ ___ _ __ __ _  ___| |_(_)_   _____\n | || '_ \\| __/ _ \\ '__/ _` |/ __| __| \\ \\ / / _ \\\n | || | | | ||  __/ | | (_| | (__| |_| |\\ V /  __/\n|___|_| |_|\\__\\___|_|  \\__,_|\\___|\\__|_| \\_/ \\___|"))
 	fmt.Printf("%s v%s\n", color.New(color.FgHiYellow).Add(color.Bold).Sprintf("Hypernet.Interactive"), pkg.AppVersion)
@@ -64,9 +69,23 @@ func main() {
 		log.Fatal().Err(err).Msg("An error occurred when running database auto migration.")
 	}
 
+	if *recalculateCounters {
+		services.RecalculatePostVoteCount()
+		services.RecalculatePublisherVoteCount()
+		services.RecalculatePollTally()
+		return
+	}
+
 	// Configure timed tasks
 	quartz := cron.New(cron.WithLogger(cron.VerbosePrintfLogger(&log.Logger)))
 	quartz.AddFunc("@every 60m", services.DoAutoDatabaseCleanup)
+	quartz.AddFunc("@daily", services.RecalculatePostVoteCount)
+	quartz.AddFunc("@daily", services.RecalculatePublisherVoteCount)
+	quartz.AddFunc("@daily", services.RecalculatePublisherFollowerCount)
+	quartz.AddFunc("@hourly", services.SendSubscriptionDigest)
+	quartz.AddFunc("@every 30s", services.DoNotificationOutboxDrain)
+	quartz.AddFunc("@every 5m", services.NotifyEndedPolls)
+	quartz.AddFunc("@every 6h", services.RecalculatePollTally)
 	quartz.Start()
 
 	// Initialize cache